@@ -15,14 +15,16 @@
 package main
 
 import (
-	"bufio"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dolthub/lambdabats/wire"
 )
@@ -37,6 +39,27 @@ type Test struct {
 	Tags []string
 	Runs []TestRun
 	File TestFile
+	// The line of the file the test is declared on.
+	Line int
+	// From a timeout:SECONDS tag; overrides --test-timeout if non-zero.
+	Timeout time.Duration
+
+	// Set if the test is known to be flaky; its failures do not fail the
+	// run.
+	Quarantine *QuarantineEntry
+}
+
+// A tag like timeout:300 sets a timeout in seconds for the test. bats only
+// allows letters, digits, _, - and : in tags, with : separating namespaces.
+const TimeoutTagPrefix = "timeout:"
+
+// How long |t| may run before it is killed, given the --test-timeout
+// |def|. Zero means no limit.
+func (t Test) TimeoutOr(def time.Duration) time.Duration {
+	if t.Timeout != 0 {
+		return t.Timeout
+	}
+	return def
 }
 
 func (t Test) HasTag(tag string) bool {
@@ -50,6 +73,30 @@ func (t Test) HasTag(tag string) bool {
 
 type TestRun struct {
 	Response wire.RunTestResult
+
+	// Where the test was run; see RunnerName.
+	Runner string
+
+	// The errors from earlier attempts at this run which failed because of
+	// infrastructure problems and were retried.
+	InfraRetries []string
+
+	// Set if running the test failed, as opposed to the test failing, for
+	// example because invoking Lambda failed after all the retries. The
+	// run is fatal, and Response.Err has the same error.
+	InfraErr string
+
+	// The run took long enough that we started a second invocation of the
+	// test to hedge against a straggler.
+	Hedged bool
+	// The second invocation finished first, so this is its result.
+	HedgeWon bool
+
+	// The wall-clock time it took to get the result back from the runner,
+	// including any infrastructure retries. Compared to the time bats
+	// reports for the test, this shows the overhead of running it in
+	// Lambda.
+	Latency time.Duration
 }
 
 type TestRunResultStatus int
@@ -58,11 +105,15 @@ const (
 	TestRunResultStatus_Success = iota
 	TestRunResultStatus_Failure
 	TestRunResultStatus_Skipped
+	TestRunResultStatus_TimedOut
 )
 
 type TestRunResult struct {
 	Status TestRunResultStatus
 	Output string
+
+	// How long bats reported the test took to run.
+	Time time.Duration
 }
 
 func (tr TestRun) Result(name string) (TestRunResult, error) {
@@ -79,6 +130,7 @@ func (tr TestRun) Result(name string) (TestRunResult, error) {
 
 	type TestCase struct {
 		Name    string  `xml:"name,attr"`
+		Time    string  `xml:"time,attr"`
 		Skipped *string `xml:"skipped"`
 		Failure *string `xml:"failure"`
 	}
@@ -90,6 +142,11 @@ func (tr TestRun) Result(name string) (TestRunResult, error) {
 		TestSuites []TestSuite `xml:"testsuite"`
 	}
 
+	// bats was killed, so its output is not a complete JUnit document.
+	if tr.Response.TimedOut {
+		return TestRunResult{Status: TestRunResultStatus_TimedOut, Output: tr.Response.Err + "\n" + tr.Response.Output}, nil
+	}
+
 	if tr.Response.Err != "" && tr.Response.Err != "exit status 1" {
 		return TestRunResult{}, errors.New(tr.Response.Err)
 	}
@@ -112,13 +169,154 @@ func (tr TestRun) Result(name string) (TestRunResult, error) {
 	if tc == nil {
 		return TestRunResult{}, fmt.Errorf("expected to find a testcase element with name \"%s\"", name)
 	}
+	var elapsed time.Duration
+	if secs, err := strconv.ParseFloat(tc.Time, 64); err == nil {
+		elapsed = time.Duration(secs * float64(time.Second))
+	}
 	if tc.Skipped != nil {
-		return TestRunResult{Status: TestRunResultStatus_Skipped, Output: *tc.Skipped}, nil
+		return TestRunResult{Status: TestRunResultStatus_Skipped, Output: *tc.Skipped, Time: elapsed}, nil
 	}
 	if tc.Failure != nil {
-		return TestRunResult{Status: TestRunResultStatus_Failure, Output: *tc.Failure}, nil
+		return TestRunResult{Status: TestRunResultStatus_Failure, Output: *tc.Failure, Time: elapsed}, nil
+	}
+	return TestRunResult{Status: TestRunResultStatus_Success, Time: elapsed}, nil
+}
+
+type TestStatus int
+
+const (
+	TestStatus_Passed TestStatus = iota
+	TestStatus_Skipped
+	// Failed on every run.
+	TestStatus_Failed
+	// Failed on some runs and passed on others.
+	TestStatus_Flaky
+	// We could not get a result out of any of the runs.
+	TestStatus_Fatal
+	// Ran longer than its timeout and was killed on every run which did
+	// not pass.
+	TestStatus_TimedOut
+	// Never started, because the run was stopped first.
+	TestStatus_NotRun
+)
+
+func (s TestStatus) String() string {
+	switch s {
+	case TestStatus_Passed:
+		return "passed"
+	case TestStatus_Skipped:
+		return "skipped"
+	case TestStatus_Failed:
+		return "failed"
+	case TestStatus_Flaky:
+		return "flaky"
+	case TestStatus_Fatal:
+		return "fatal"
+	case TestStatus_TimedOut:
+		return "timeout"
+	case TestStatus_NotRun:
+		return "not_run"
 	}
-	return TestRunResult{Status: TestRunResultStatus_Success}, nil
+	return "unknown"
+}
+
+// The outcome of all the runs of a test.
+type TestSummary struct {
+	Status TestStatus
+
+	Passed   int
+	Failed   int
+	Skipped  int
+	Fatal    int
+	TimedOut int
+
+	// The total number of infrastructure retries across all the runs.
+	InfraRetries int
+
+	// The first run which failed or was fatal, if any. Its output is
+	// reported as the reason the test failed.
+	Failure *TestRun
+	// The result of Failure, if it was not fatal.
+	FailureResult TestRunResult
+	// The error getting the result of Failure, if it was fatal.
+	FailureErr error
+
+	// The reason given for skipping the test, if it was skipped.
+	SkipReason string
+}
+
+func (s TestSummary) Runs() int {
+	return s.Passed + s.Failed + s.Skipped + s.Fatal + s.TimedOut
+}
+
+// The number of times we invoked the test, including infrastructure retries.
+func (s TestSummary) Attempts() int {
+	return s.Runs() + s.InfraRetries
+}
+
+// Failed, fatal, timed out or flaky.
+func (s TestSummary) Failing() bool {
+	return s.Status == TestStatus_Failed || s.Status == TestStatus_Fatal || s.Status == TestStatus_TimedOut || s.Status == TestStatus_Flaky
+}
+
+func (t Test) Summary() TestSummary {
+	var s TestSummary
+	for i, run := range t.Runs {
+		s.InfraRetries += len(run.InfraRetries)
+		res, err := run.Result(t.Name)
+		if err != nil {
+			s.Fatal += 1
+			if s.Failure == nil {
+				s.Failure, s.FailureErr = &t.Runs[i], err
+			}
+		} else if res.Status == TestRunResultStatus_Failure {
+			s.Failed += 1
+			if s.Failure == nil {
+				s.Failure, s.FailureResult = &t.Runs[i], res
+			}
+		} else if res.Status == TestRunResultStatus_TimedOut {
+			s.TimedOut += 1
+			if s.Failure == nil {
+				s.Failure, s.FailureResult = &t.Runs[i], res
+			}
+		} else if res.Status == TestRunResultStatus_Skipped {
+			s.Skipped += 1
+			s.SkipReason = res.Output
+		} else {
+			s.Passed += 1
+		}
+	}
+	if len(t.Runs) == 0 {
+		s.Status = TestStatus_NotRun
+	} else if s.Passed > 0 && s.Failed+s.Fatal+s.TimedOut > 0 {
+		s.Status = TestStatus_Flaky
+	} else if s.Failed > 0 {
+		s.Status = TestStatus_Failed
+	} else if s.TimedOut > 0 {
+		s.Status = TestStatus_TimedOut
+	} else if s.Fatal > 0 {
+		s.Status = TestStatus_Fatal
+	} else if s.Skipped > 0 && s.Passed == 0 {
+		s.Status = TestStatus_Skipped
+	} else {
+		s.Status = TestStatus_Passed
+	}
+	return s
+}
+
+// The mean of the durations bats reported for the runs of the test. Runs
+// which did not complete are counted as taking no time.
+func (t Test) MeanDuration() time.Duration {
+	if len(t.Runs) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, run := range t.Runs {
+		if res, err := run.Result(t.Name); err == nil {
+			total += res.Time
+		}
+	}
+	return total / time.Duration(len(t.Runs))
 }
 
 func SkippedJUnitTestCaseOutput(filename, testname, reason string) string {
@@ -135,7 +333,7 @@ func SkippedJUnitTestCaseOutput(filename, testname, reason string) string {
 }
 
 // Read the *.bats files in a directory and collect the tests found in them.
-func LoadTestFiles(args []string, duplicateCnt int) ([]TestFile, int, error) {
+func LoadTestFiles(args []string) ([]TestFile, int, error) {
 	numTests := 0
 	var files []TestFile
 
@@ -181,46 +379,126 @@ func LoadTestFiles(args []string, duplicateCnt int) ([]TestFile, int, error) {
 		}
 	}
 
+	var collisions []TestCollision
 	for i := range files {
 		var err error
-		files[i].Tests, err = LoadTests(fileSys, files[i], duplicateCnt)
+		files[i].Tests, err = LoadTests(fileSys, files[i])
 		if err != nil {
 			return nil, 0, err
 		}
+		fileCollisions, err := FindTestCollisions(files[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		collisions = append(collisions, fileCollisions...)
 		numTests += len(files[i].Tests)
 	}
+	if len(collisions) > 0 {
+		return nil, 0, &TestCollisionError{Collisions: collisions}
+	}
 
 	return files, numTests, nil
 }
 
-func LoadTests(fileSys fs.FS, tf TestFile, duplicateCnt int) ([]Test, error) {
+func LoadTests(fileSys fs.FS, tf TestFile) ([]Test, error) {
 	f, err := fileSys.Open(tf.Name)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	var tags []string
+	decls, err := parseBatsFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", tf.Name, err)
+	}
 	var res []Test
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		line := s.Text()
-		if strings.HasPrefix(line, "# bats test_tags=") {
-			line = strings.TrimPrefix(line, "# bats test_tags=")
-			tags = strings.Split(line, " ")
-		} else if strings.HasPrefix(line, "@test \"") {
-			line = strings.TrimPrefix(line, "@test \"")
-			line = strings.TrimRight(line, "\" {")
-			for range duplicateCnt {
-				res = append(res, Test{Name: line, Tags: tags, File: tf})
+	for _, d := range decls {
+		t := Test{Name: d.Name, Tags: d.AllTags(), Line: d.Line, File: tf}
+		for _, tag := range t.Tags {
+			if secs, ok := strings.CutPrefix(tag, TimeoutTagPrefix); ok {
+				n, err := strconv.Atoi(secs)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("error loading %s: line %d: invalid timeout tag %q; expected a number of seconds", tf.Name, d.Line, tag)
+				}
+				t.Timeout = time.Duration(n) * time.Second
 			}
-			tags = nil
 		}
+		res = append(res, t)
 	}
-	return res, s.Err()
+	return res, nil
 }
 
+// Returns a filter for `bats -f` which matches exactly the test named |n|.
+// bats matches the filter against test names with bash's =~, which uses
+// POSIX extended regular expressions. Every character which is special in
+// an ERE is special in RE2 as well, and escaping it with a backslash makes it
+// literal in both, so the filter means the same thing to bats as it does to
+// the regexp package.
 func EscapeNameForFilter(n string) string {
-	escaped := strings.ReplaceAll(n, "(", "\\(")
-	escaped = strings.ReplaceAll(escaped, "+", "\\+")
-	return "^" + escaped + "$"
+	return "^" + regexp.QuoteMeta(n) + "$"
+}
+
+// Tests in the same file which cannot be told apart: the bats filter for
+// one of them also matches the others, and their results in the JUnit output
+// would have the same name.
+type TestCollision struct {
+	File  string
+	Names []string
+	Lines []int
+}
+
+func (c TestCollision) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:", c.File)
+	for i := range c.Names {
+		fmt.Fprintf(&b, " %q (line %d)", c.Names[i], c.Lines[i])
+		if i < len(c.Names)-1 {
+			b.WriteString(",")
+		}
+	}
+	return b.String()
+}
+
+// Returned by LoadTestFiles when some tests cannot be run individually.
+type TestCollisionError struct {
+	Collisions []TestCollision
+}
+
+func (e *TestCollisionError) Error() string {
+	var b strings.Builder
+	b.WriteString("error loading test files: found tests with colliding names; rename them so that each can be run on its own:")
+	for _, c := range e.Collisions {
+		b.WriteString("\n  ")
+		b.WriteString(c.String())
+	}
+	return b.String()
+}
+
+// Find the tests in |tf| whose filters, as generated by EscapeNameForFilter,
+// match more than one test in the file. Running any of them with `bats -f`
+// would run all of them.
+func FindTestCollisions(tf TestFile) ([]TestCollision, error) {
+	var res []TestCollision
+	reported := make(map[int]bool)
+	for i, t := range tf.Tests {
+		if reported[i] {
+			continue
+		}
+		filter := EscapeNameForFilter(t.Name)
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s: invalid filter %q for test %q: %w", tf.Name, filter, t.Name, err)
+		}
+		c := TestCollision{File: tf.Name}
+		for j, other := range tf.Tests {
+			if re.MatchString(other.Name) {
+				c.Names = append(c.Names, other.Name)
+				c.Lines = append(c.Lines, other.Line)
+				reported[j] = true
+			}
+		}
+		if len(c.Names) > 1 {
+			res = append(res, c)
+		}
+	}
+	return res, nil
 }
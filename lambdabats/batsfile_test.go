@@ -1,8 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -111,3 +119,241 @@ time=&quot;2023-12-21T23:47:55Z&quot; level=info msg=&quot;http server exited. e
 	assert.Equal(t, int(res.Status), TestRunResultStatus_Failure)
 	assert.True(t, strings.Contains(res.Output, "http server exited"))
 }
+
+func TestTestSummary(t *testing.T) {
+	passed := TestRun{Response: wire.RunTestResult{Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0.1">
+<testsuite name="a.bats" tests="1" failures="0" errors="0" skipped="0" time="0.1">
+    <testcase classname="a.bats" name="a: test" time="0.1" />
+</testsuite>
+</testsuites>
+`}}
+	failed := TestRun{Response: wire.RunTestResult{Err: "exit status 1", Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0.1">
+<testsuite name="a.bats" tests="1" failures="1" errors="0" skipped="0" time="0.1">
+    <testcase classname="a.bats" name="a: test" time="0.1">
+        <failure type="failure">(in test file a.bats, line 3)</failure>
+    </testcase>
+</testsuite>
+</testsuites>
+`}}
+	fatal := TestRun{Response: wire.RunTestResult{Err: "signal: killed"}}
+
+	s := Test{Name: "a: test", Runs: []TestRun{passed}}.Summary()
+	assert.Equal(t, TestStatus_Passed, s.Status)
+	assert.Nil(t, s.Failure)
+
+	s = Test{Name: "a: test", Runs: []TestRun{failed, passed, passed}}.Summary()
+	assert.Equal(t, TestStatus_Flaky, s.Status)
+	assert.Equal(t, 2, s.Passed)
+	assert.Equal(t, 3, s.Runs())
+	assert.Contains(t, s.FailureResult.Output, "line 3")
+
+	failures := Test{Name: "a: test", Runs: []TestRun{failed, fatal, passed, failed}}.DistinctFailures()
+	if assert.Len(t, failures, 2) {
+		assert.Equal(t, 2, failures[0].Count)
+		assert.Contains(t, failures[0].Output, "line 3")
+		assert.Equal(t, 1, failures[1].Count)
+	}
+
+	s = Test{Name: "a: test", Runs: []TestRun{failed, fatal}}.Summary()
+	assert.Equal(t, TestStatus_Failed, s.Status)
+	assert.True(t, s.Failing())
+
+	s = Test{Name: "a: test", Runs: []TestRun{fatal}}.Summary()
+	assert.Equal(t, TestStatus_Fatal, s.Status)
+	assert.Error(t, s.FailureErr)
+
+	timedOut := TestRun{Response: wire.RunTestResult{Err: "timed out after 60s", Output: "partial", TimedOut: true}}
+	s = Test{Name: "a: test", Runs: []TestRun{timedOut, fatal}}.Summary()
+	assert.Equal(t, TestStatus_TimedOut, s.Status)
+	assert.Equal(t, "timeout", s.Status.String())
+	assert.True(t, s.Failing())
+	assert.Contains(t, s.FailureResult.Output, "partial")
+
+	s = Test{Name: "a: test"}.Summary()
+	assert.Equal(t, TestStatus_NotRun, s.Status)
+	assert.False(t, s.Failing())
+
+	s = Test{Name: "a: test", Runs: []TestRun{timedOut, passed}}.Summary()
+	assert.Equal(t, TestStatus_Flaky, s.Status)
+	assert.Equal(t, 0.5, s.FailureRate())
+
+	skipped := TestRun{Response: wire.RunTestResult{Output: SkippedJUnitTestCaseOutput("a.bats", "a: test", "no tty")}}
+	s = Test{Name: "a: test", Runs: []TestRun{skipped}}.Summary()
+	assert.Equal(t, TestStatus_Skipped, s.Status)
+	assert.Equal(t, "no tty", s.SkipReason)
+}
+
+func TestParseBatsFile(t *testing.T) {
+	type expected struct {
+		Name     string   `json:"name"`
+		Line     int      `json:"line"`
+		Tags     []string `json:"tags"`
+		FileTags []string `json:"file_tags"`
+	}
+	paths, err := filepath.Glob("testdata/parse/*.bats")
+	if !assert.NoError(t, err) || !assert.NotEmpty(t, paths) {
+		return
+	}
+	for _, path := range paths {
+		base := strings.TrimSuffix(path, ".bats")
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			f, err := os.Open(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer f.Close()
+			decls, err := parseBatsFile(f)
+
+			if errMsg, rerr := os.ReadFile(base + ".err"); rerr == nil {
+				var syntaxErr *BatsSyntaxError
+				if assert.ErrorAs(t, err, &syntaxErr) {
+					assert.Equal(t, strings.TrimSpace(string(errMsg)), err.Error())
+				}
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			contents, err := os.ReadFile(base + ".json")
+			if !assert.NoError(t, err) {
+				return
+			}
+			var want []expected
+			if !assert.NoError(t, json.Unmarshal(contents, &want)) {
+				return
+			}
+			got := make([]expected, len(decls))
+			for i, d := range decls {
+				got[i] = expected{Name: d.Name, Line: d.Line, Tags: d.Tags, FileTags: d.FileTags}
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestLoadTests(t *testing.T) {
+	fileSys := fstest.MapFS{
+		"a.bats": {Data: []byte("# bats test_tags=no_lambda\n@test 'a: \"quoted\" {' {\n}\n")},
+		"c.bats": {Data: []byte("# bats file_tags=no_lambda, slow\n\n# bats test_tags=slow,tty\n@test \"c: tagged\" {\n}\n\n@test \"c: untagged\" {\n}\n")},
+		"b.bats": {Data: []byte("@test \"b: unterminated {\n}\n")},
+		"d.bats": {Data: []byte("# bats test_tags=timeout:300\n@test \"d: slow\" {\n}\n\n@test \"d: default\" {\n}\n")},
+		"e.bats": {Data: []byte("# bats test_tags=timeout:soon\n@test \"e: bad\" {\n}\n")},
+		"f.bats": {Data: []byte("# bats test_tags=timeout=300\n@test \"f: old style\" {\n}\n")},
+	}
+	tests, err := LoadTests(fileSys, TestFile{Name: "a.bats"})
+	if assert.NoError(t, err) && assert.Len(t, tests, 1) {
+		assert.Equal(t, `a: "quoted" {`, tests[0].Name)
+		assert.Equal(t, []string{"no_lambda"}, tests[0].Tags)
+		assert.Equal(t, 2, tests[0].Line)
+		assert.Equal(t, "a.bats", tests[0].File.Name)
+	}
+
+	tests, err = LoadTests(fileSys, TestFile{Name: "c.bats"})
+	if assert.NoError(t, err) && assert.Len(t, tests, 2) {
+		assert.Equal(t, []string{"no_lambda", "slow", "tty"}, tests[0].Tags)
+		assert.Equal(t, []string{"no_lambda", "slow"}, tests[1].Tags)
+		assert.True(t, tests[1].HasTag("no_lambda"))
+	}
+
+	_, err = LoadTests(fileSys, TestFile{Name: "b.bats"})
+	assert.ErrorContains(t, err, "b.bats: line 1")
+
+	tests, err = LoadTests(fileSys, TestFile{Name: "d.bats"})
+	if assert.NoError(t, err) && assert.Len(t, tests, 2) {
+		assert.Equal(t, 300*time.Second, tests[0].TimeoutOr(time.Minute))
+		assert.Equal(t, time.Minute, tests[1].TimeoutOr(time.Minute))
+	}
+	_, err = LoadTests(fileSys, TestFile{Name: "e.bats"})
+	assert.ErrorContains(t, err, `e.bats: line 2: invalid timeout tag "timeout:soon"`)
+
+	_, err = LoadTests(fileSys, TestFile{Name: "f.bats"})
+	assert.ErrorContains(t, err, `f.bats: line 1: invalid tag "timeout=300"`)
+}
+
+func TestEscapeNameForFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		// Other names which the filter must not match.
+		others []string
+	}{
+		{"plain: name", nil},
+		{"dots: a.b", []string{"dots: axb"}},
+		{"stars: a* and b+", []string{"stars: aaa and bb"}},
+		{"brackets: [x] {1,2}", []string{"brackets: x {1,2}", "brackets: [x] 1"}},
+		{"braces: a{2} {", []string{"braces: aa {"}},
+		{"questions: a? (b)", []string{"questions:  b"}},
+		{"alternation: a|b", []string{"b", "alternation: a"}},
+		{"anchors: ^start $end", nil},
+		{`backslashes: a\b \\ \`, []string{`backslashes: ab \ `}},
+		{"newlines: a\nb", []string{"newlines: a", "b", "newlines: a\n", "newlines: a\nb\n"}},
+	}
+	// bats matches the filter with bash's =~, so check that bash agrees
+	// with the regexp package where it is installed.
+	bash, bashErr := exec.LookPath("bash")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := EscapeNameForFilter(test.name)
+			re := regexp.MustCompile(filter)
+			assert.True(t, re.MatchString(test.name))
+			if bashErr == nil {
+				assert.True(t, bashMatches(t, bash, test.name, filter))
+			}
+			others := append([]string{test.name + "x", "x" + test.name}, test.others...)
+			for _, other := range others {
+				assert.False(t, re.MatchString(other), other)
+				if bashErr == nil {
+					assert.False(t, bashMatches(t, bash, other, filter), other)
+				}
+			}
+		})
+	}
+}
+
+// Whether bash's =~ matches |s| against the regular expression |filter|.
+func bashMatches(t *testing.T, bash, s, filter string) bool {
+	err := exec.Command(bash, "-c", `[[ $1 =~ $2 ]]`, "bash", s, filter).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false
+	}
+	assert.NoError(t, err, "bash could not match %q against %q", s, filter)
+	return err == nil
+}
+
+func TestFindTestCollisions(t *testing.T) {
+	tf := TestFile{Name: "a.bats", Tests: []Test{
+		{Name: "a: one", Line: 1},
+		{Name: "a: one.", Line: 4},
+		{Name: "a: two", Line: 7},
+		{Name: "a: one", Line: 10},
+		{Name: "a: two", Line: 13},
+		{Name: "a: one", Line: 16},
+	}}
+	collisions, err := FindTestCollisions(tf)
+	assert.NoError(t, err)
+	if assert.Len(t, collisions, 2) {
+		assert.Equal(t, []int{1, 10, 16}, collisions[0].Lines)
+		assert.Equal(t, []int{7, 13}, collisions[1].Lines)
+		assert.Equal(t, `a.bats: "a: two" (line 7), "a: two" (line 13)`, collisions[1].String())
+	}
+
+	collisions, err = FindTestCollisions(TestFile{Name: "b.bats", Tests: tf.Tests[:3]})
+	assert.NoError(t, err)
+	assert.Empty(t, collisions)
+
+	dir := t.TempDir()
+	contents := "@test \"c: dup\" {\n}\n\n@test 'c: dup' {\n}\n"
+	if !assert.NoError(t, os.WriteFile(filepath.Join(dir, "c.bats"), []byte(contents), 0644)) {
+		return
+	}
+	_, _, err = LoadTestFiles([]string{dir})
+	var collisionErr *TestCollisionError
+	if assert.ErrorAs(t, err, &collisionErr) && assert.Len(t, collisionErr.Collisions, 1) {
+		assert.Equal(t, []int{1, 4}, collisionErr.Collisions[0].Lines)
+	}
+	assert.ErrorContains(t, err, `c.bats: "c: dup" (line 1), "c: dup" (line 4)`)
+}
@@ -0,0 +1,222 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/fatih/color"
+)
+
+// A test in the results being compared, with its status.
+type DiffTest struct {
+	File   string
+	Name   string
+	Status string
+}
+
+func failingStatus(status string) bool {
+	return status == "failed" || status == "fatal" || status == "timeout" || status == "flaky"
+}
+
+// Load the status of every test in |arg|, which is either the path to a
+// file of -F json results or of a history record, or the ID of a run in
+// the history.
+func LoadDiffTests(arg string) ([]DiffTest, error) {
+	var bs []byte
+	fi, err := os.Stat(arg)
+	if err == nil && !fi.IsDir() {
+		bs, err = os.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		run, err := LoadHistoryRun(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s is neither a results file nor a run in the history: %w", arg, err)
+		}
+		bs, err = json.Marshal(run)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		return nil, fmt.Errorf("%s is a directory", arg)
+	}
+
+	// -F json results have files, while history records have tests.
+	var parsed struct {
+		Files []JSONTestFile `json:"files"`
+		Tests []HistoryTest  `json:"tests"`
+	}
+	err = json.Unmarshal(bs, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", arg, err)
+	}
+	var res []DiffTest
+	for _, f := range parsed.Files {
+		for _, t := range f.Tests {
+			res = append(res, DiffTest{File: f.Name, Name: t.Name, Status: t.Status})
+		}
+	}
+	for _, t := range parsed.Tests {
+		res = append(res, DiffTest{File: t.File, Name: t.Name, Status: t.Status})
+	}
+	return res, nil
+}
+
+// How a test changed from one set of results to another.
+type RunDiff struct {
+	NewlyFailing []DiffTest
+	NewlyPassing []DiffTest
+	StillFailing []DiffTest
+	NewlySkipped []DiffTest
+	Added        []DiffTest
+	Removed      []DiffTest
+	// Tests which were not run in |b|, because it was interrupted or
+	// stopped by --fail-fast, so how they changed is not known.
+	NotRun       []DiffTest
+	NumUnchanged int
+}
+
+// Returns true if any test fails in |b| which did not fail in |a|,
+// including tests which were added and fail.
+func (d RunDiff) HasNewFailures() bool {
+	if len(d.NewlyFailing) > 0 {
+		return true
+	}
+	for _, t := range d.Added {
+		if failingStatus(t.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify each test by how its status changed from |a| to |b|. Tests are
+// compared by file and name. The tests in each category are sorted, and
+// hold their status in |b|, or in |a| for the removed ones.
+func DiffRuns(a, b []DiffTest) RunDiff {
+	type key struct{ file, name string }
+	before := make(map[key]DiffTest)
+	for _, t := range a {
+		before[key{t.File, t.Name}] = t
+	}
+	var d RunDiff
+	seen := make(map[key]bool)
+	for _, t := range b {
+		k := key{t.File, t.Name}
+		seen[k] = true
+		prev, ok := before[k]
+		switch {
+		case t.Status == "not_run":
+			d.NotRun = append(d.NotRun, t)
+		case !ok:
+			d.Added = append(d.Added, t)
+		case failingStatus(t.Status) && failingStatus(prev.Status):
+			d.StillFailing = append(d.StillFailing, t)
+		case failingStatus(t.Status):
+			d.NewlyFailing = append(d.NewlyFailing, t)
+		case t.Status == "skipped" && prev.Status != "skipped":
+			d.NewlySkipped = append(d.NewlySkipped, t)
+		case failingStatus(prev.Status):
+			d.NewlyPassing = append(d.NewlyPassing, t)
+		default:
+			d.NumUnchanged += 1
+		}
+	}
+	for _, t := range a {
+		if !seen[key{t.File, t.Name}] {
+			d.Removed = append(d.Removed, t)
+		}
+	}
+	for _, tests := range [][]DiffTest{d.NewlyFailing, d.NewlyPassing, d.StillFailing, d.NewlySkipped, d.Added, d.Removed, d.NotRun} {
+		sort.Slice(tests, func(i, j int) bool {
+			if tests[i].File != tests[j].File {
+				return tests[i].File < tests[j].File
+			}
+			return tests[i].Name < tests[j].Name
+		})
+	}
+	return d
+}
+
+func WriteRunDiff(w io.Writer, d RunDiff) {
+	section := func(c *color.Color, title string, tests []DiffTest, status bool) {
+		if len(tests) == 0 {
+			return
+		}
+		c.Fprintf(w, "%s (%d)\n", title, len(tests))
+		for _, t := range tests {
+			if status {
+				fmt.Fprintf(w, "  %s: %s (%s)\n", t.File, t.Name, t.Status)
+			} else {
+				fmt.Fprintf(w, "  %s: %s\n", t.File, t.Name)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	red := color.New(color.FgRed)
+	green := color.New(color.FgGreen)
+	yellow := color.New(color.FgYellow)
+	blue := color.New(color.FgBlue)
+	section(red, "newly failing", d.NewlyFailing, true)
+	section(green, "newly passing", d.NewlyPassing, false)
+	section(yellow, "still failing", d.StillFailing, true)
+	section(yellow, "newly skipped", d.NewlySkipped, false)
+	section(blue, "added", d.Added, true)
+	section(blue, "removed", d.Removed, true)
+	section(yellow, "not run", d.NotRun, false)
+	notRun := ""
+	if len(d.NotRun) > 0 {
+		notRun = fmt.Sprintf(", %d not run", len(d.NotRun))
+	}
+	fmt.Fprintf(w, "%d newly failing, %d newly passing, %d still failing, %d newly skipped, %d added, %d removed%s, %d unchanged\n",
+		len(d.NewlyFailing), len(d.NewlyPassing), len(d.StillFailing), len(d.NewlySkipped), len(d.Added), len(d.Removed), notRun, d.NumUnchanged)
+	if len(d.NotRun) > 0 {
+		yellow.Fprintf(w, "The second run did not run %d of its tests, so the comparison is incomplete.\n", len(d.NotRun))
+	}
+}
+
+// Compare two runs, and exit non-zero if the second has new failures.
+func DoDiff(args []string) int {
+	if len(args) != 2 {
+		fmt.Println("usage: lambda-bats diff RUN_A RUN_B")
+		fmt.Println("  RUN_A and RUN_B are run IDs from `lambda-bats history list`, or files of -F json results.")
+		return 1
+	}
+	a, err := LoadDiffTests(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	b, err := LoadDiffTests(args[1])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	d := DiffRuns(a, b)
+	WriteRunDiff(os.Stdout, d)
+	if d.HasNewFailures() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestDiffRuns(t *testing.T) {
+	a := []DiffTest{
+		{"a.bats", "passes", "passed"},
+		{"a.bats", "breaks", "passed"},
+		{"a.bats", "fixed", "failed"},
+		{"a.bats", "broken", "fatal"},
+		{"a.bats", "now skipped", "passed"},
+		{"b.bats", "removed", "passed"},
+	}
+	b := []DiffTest{
+		{"a.bats", "passes", "passed"},
+		{"a.bats", "breaks", "flaky"},
+		{"a.bats", "fixed", "passed"},
+		{"a.bats", "broken", "failed"},
+		{"a.bats", "now skipped", "skipped"},
+		{"c.bats", "added", "passed"},
+	}
+	d := DiffRuns(a, b)
+	assert.Equal(t, []DiffTest{{"a.bats", "breaks", "flaky"}}, d.NewlyFailing)
+	assert.Equal(t, []DiffTest{{"a.bats", "fixed", "passed"}}, d.NewlyPassing)
+	assert.Equal(t, []DiffTest{{"a.bats", "broken", "failed"}}, d.StillFailing)
+	assert.Equal(t, []DiffTest{{"a.bats", "now skipped", "skipped"}}, d.NewlySkipped)
+	assert.Equal(t, []DiffTest{{"c.bats", "added", "passed"}}, d.Added)
+	assert.Equal(t, []DiffTest{{"b.bats", "removed", "passed"}}, d.Removed)
+	assert.Equal(t, 1, d.NumUnchanged)
+	assert.True(t, d.HasNewFailures())
+
+	d = DiffRuns(a, a)
+	assert.False(t, d.HasNewFailures())
+	assert.Len(t, d.StillFailing, 2)
+	assert.Equal(t, 4, d.NumUnchanged)
+
+	d = DiffRuns(nil, []DiffTest{{"c.bats", "added", "failed"}})
+	assert.True(t, d.HasNewFailures())
+
+	// The tests an interrupted run did not get to are not unchanged.
+	partial := []DiffTest{
+		{"a.bats", "passes", "passed"},
+		{"a.bats", "breaks", "not_run"},
+		{"a.bats", "fixed", "not_run"},
+		{"a.bats", "broken", "fatal"},
+		{"a.bats", "now skipped", "passed"},
+		{"b.bats", "removed", "passed"},
+	}
+	d = DiffRuns(a, partial)
+	assert.Equal(t, []DiffTest{{"a.bats", "breaks", "not_run"}, {"a.bats", "fixed", "not_run"}}, d.NotRun)
+	assert.Empty(t, d.NewlyPassing)
+	assert.Equal(t, 3, d.NumUnchanged)
+	var buf bytes.Buffer
+	WriteRunDiff(&buf, d)
+	assert.Contains(t, buf.String(), "not run (2)\n  a.bats: breaks\n  a.bats: fixed\n")
+	assert.Contains(t, buf.String(), ", 2 not run, 3 unchanged\n")
+	assert.Contains(t, buf.String(), "the comparison is incomplete")
+}
+
+func TestLoadDiffTests(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	assert.NoError(t, SaveHistoryRun(HistoryRun{ID: "run", Time: time.Now(), Tests: []HistoryTest{
+		{File: "a.bats", Name: "a", Status: "failed"},
+	}}))
+	tests, err := LoadDiffTests("run")
+	assert.NoError(t, err)
+	assert.Equal(t, []DiffTest{{"a.bats", "a", "failed"}}, tests)
+
+	path := filepath.Join(t.TempDir(), "results.json")
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	passed := TestRun{Response: wire.RunTestResult{Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0">
+<testsuite name="b.bats" tests="1" failures="0" errors="0" skipped="0" time="0">
+    <testcase classname="b.bats" name="b" time="0" />
+</testsuite>
+</testsuites>
+`}}
+	files := []TestFile{{Name: "b.bats", Tests: []Test{{Name: "b", Runs: []TestRun{passed}}}}}
+	assert.NoError(t, WriteJSONResults(f, files, RunInfo{}))
+	assert.NoError(t, f.Close())
+	tests, err = LoadDiffTests(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []DiffTest{{"b.bats", "b", "passed"}}, tests)
+
+	_, err = LoadDiffTests("missing")
+	assert.Error(t, err)
+}
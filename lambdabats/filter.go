@@ -0,0 +1,141 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Selects which of the tests found by LoadTestFiles actually get run. The
+// zero value selects every test.
+type TestFilter struct {
+	// If non-nil, only tests whose names match this regex are run. Like
+	// `bats -f`.
+	Name *regexp.Regexp
+
+	// Each entry is a list of tags, as passed to `bats --filter-tags`. A
+	// test is selected if it matches every tag in at least one of the
+	// entries. A tag can be negated with a leading `!`.
+	Tags [][]string
+
+	// If non-nil, only tests in this set are run. Keyed by file name and
+	// then test name.
+	Only map[string]map[string]bool
+}
+
+// Parse the argument to a single --filter-tags flag. Tags are comma
+// separated. An empty list only matches tests without any tags.
+func ParseFilterTags(arg string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(arg, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func matchesTags(t Test, tags []string) bool {
+	if len(tags) == 0 {
+		return len(t.Tags) == 0
+	}
+	for _, tag := range tags {
+		if negated, ok := strings.CutPrefix(tag, "!"); ok {
+			if t.HasTag(negated) {
+				return false
+			}
+		} else if !t.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f TestFilter) Matches(t Test) bool {
+	if f.Name != nil && !f.Name.MatchString(t.Name) {
+		return false
+	}
+	if f.Only != nil && !f.Only[t.File.Name][t.Name] {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		for _, tags := range f.Tags {
+			if matchesTags(t, tags) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Returns the files with only the selected tests in them, and the total
+// number of tests selected. Files with no selected tests are dropped.
+func FilterTestFiles(files []TestFile, filter TestFilter) ([]TestFile, int) {
+	var res []TestFile
+	numTests := 0
+	for _, f := range files {
+		var tests []Test
+		for _, t := range f.Tests {
+			if filter.Matches(t) {
+				tests = append(tests, t)
+			}
+		}
+		if len(tests) > 0 {
+			f.Tests = tests
+			res = append(res, f)
+			numTests += len(tests)
+		}
+	}
+	return res, numTests
+}
+
+var ErrNoRecordedRun = errors.New("no recorded run found for these tests")
+
+// Load the tests which failed, were fatal or timed out in the last run in
+// the history, in the form expected by TestFilter.Only. These are the same
+// tests `rerun-failed` reruns. Returns ErrNoRecordedRun if the last run was
+// against a different directory than |batsDir|, or if there is no recorded
+// run.
+func LoadFailedTests(batsDir string) (map[string]map[string]bool, error) {
+	run, err := LoadLastHistoryRun()
+	if err != nil {
+		return nil, err
+	}
+	if run.BatsDir != batsDir {
+		return nil, ErrNoRecordedRun
+	}
+	return run.FailedTests(), nil
+}
+
+const FocusTag = "bats:focus"
+
+// Like bats, if any of the tests are tagged bats:focus, only those tests are
+// run. Returns the focused tests, the number of them, and whether focus mode
+// is active. Otherwise returns files unchanged.
+func FocusTestFiles(files []TestFile, numTests int) ([]TestFile, int, bool) {
+	for _, f := range files {
+		for _, t := range f.Tests {
+			if t.HasTag(FocusTag) {
+				files, numTests = FilterTestFiles(files, TestFilter{Tags: [][]string{{FocusTag}}})
+				return files, numTests, true
+			}
+		}
+	}
+	return files, numTests, false
+}
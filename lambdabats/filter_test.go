@@ -0,0 +1,93 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestFilter(t *testing.T) {
+	file := TestFile{Name: "sql-shell.bats"}
+	untagged := Test{Name: "sql-shell: basic", File: file}
+	noLambda := Test{Name: "sql-shell: pipe", Tags: []string{"no_lambda"}, File: file}
+	both := Test{Name: "sql-shell: tty", Tags: []string{"no_lambda", "tty"}, File: file}
+
+	assert.True(t, TestFilter{}.Matches(untagged))
+	assert.True(t, TestFilter{}.Matches(both))
+
+	byName := TestFilter{Name: regexp.MustCompile("pipe$")}
+	assert.False(t, byName.Matches(untagged))
+	assert.True(t, byName.Matches(noLambda))
+
+	and := TestFilter{Tags: [][]string{ParseFilterTags("no_lambda,tty")}}
+	assert.False(t, and.Matches(untagged))
+	assert.False(t, and.Matches(noLambda))
+	assert.True(t, and.Matches(both))
+
+	negated := TestFilter{Tags: [][]string{ParseFilterTags("no_lambda,!tty")}}
+	assert.False(t, negated.Matches(untagged))
+	assert.True(t, negated.Matches(noLambda))
+	assert.False(t, negated.Matches(both))
+
+	or := TestFilter{Tags: [][]string{ParseFilterTags("tty"), ParseFilterTags("")}}
+	assert.True(t, or.Matches(untagged))
+	assert.False(t, or.Matches(noLambda))
+	assert.True(t, or.Matches(both))
+
+	only := TestFilter{Only: map[string]map[string]bool{"sql-shell.bats": {"sql-shell: pipe": true}}}
+	assert.False(t, only.Matches(untagged))
+	assert.True(t, only.Matches(noLambda))
+
+	files, total := FilterTestFiles([]TestFile{
+		{Name: file.Name, Tests: []Test{untagged, noLambda, both}},
+		{Name: "empty.bats", Tests: []Test{{Name: "empty: nothing", File: TestFile{Name: "empty.bats"}}}},
+	}, and)
+	assert.Equal(t, 1, total)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, "sql-shell: tty", files[0].Tests[0].Name)
+	}
+}
+
+func TestFocusTestFiles(t *testing.T) {
+	files := []TestFile{
+		{Name: "a.bats", Tests: []Test{{Name: "a: one"}, {Name: "a: two", Tags: []string{FocusTag}}}},
+		{Name: "b.bats", Tests: []Test{{Name: "b: one"}}},
+	}
+	focused, total, focus := FocusTestFiles(files, 3)
+	assert.True(t, focus)
+	assert.Equal(t, 1, total)
+	if assert.Len(t, focused, 1) {
+		assert.Equal(t, "a: two", focused[0].Tests[0].Name)
+	}
+
+	unfocused, total, focus := FocusTestFiles(files[1:], 1)
+	assert.False(t, focus)
+	assert.Equal(t, 1, total)
+	assert.Len(t, unfocused, 1)
+}
+
+func TestLoadFailedTests(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	_, err := LoadFailedTests("/dolt/integration-tests/bats")
+	assert.ErrorIs(t, err, ErrNoRecordedRun)
+
+	run := HistoryRun{ID: "20231001-120000-aaaaaaaa", BatsDir: "/dolt/integration-tests/bats", Tests: []HistoryTest{
+		{File: "a.bats", Name: "passed", Status: "passed"},
+		{File: "a.bats", Name: "failed", Status: "failed"},
+		{File: "a.bats", Name: "flaky", Status: "flaky"},
+		{File: "b.bats", Name: "timeout", Status: "timeout"},
+		{File: "b.bats", Name: "not run", Status: "not_run"},
+	}}
+	assert.NoError(t, SaveHistoryRun(run))
+	failed, err := LoadFailedTests("/dolt/integration-tests/bats")
+	assert.NoError(t, err)
+	assert.Equal(t, run.FailedTests(), failed)
+	assert.Equal(t, map[string]map[string]bool{
+		"a.bats": {"failed": true},
+		"b.bats": {"timeout": true},
+	}, failed)
+
+	_, err = LoadFailedTests("/other/integration-tests/bats")
+	assert.ErrorIs(t, err, ErrNoRecordedRun)
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// One of the distinct outputs which the runs of a test failed with.
+type DistinctFailure struct {
+	Output string `json:"output"`
+	// How many runs failed with exactly this output.
+	Count int `json:"count"`
+}
+
+// The output of a run which failed, was fatal or timed out, and false if it
+// passed or was skipped.
+func (tr TestRun) FailureOutput(name string) (string, bool) {
+	res, err := tr.Result(name)
+	if err != nil {
+		return tr.Response.Err + "\n" + tr.Response.Output, true
+	}
+	if res.Status == TestRunResultStatus_Failure || res.Status == TestRunResultStatus_TimedOut {
+		return res.Output, true
+	}
+	return "", false
+}
+
+// The distinct outputs the runs of |t| failed with, most common first.
+func (t Test) DistinctFailures() []DistinctFailure {
+	var res []DistinctFailure
+	idx := make(map[string]int)
+	for _, run := range t.Runs {
+		output, failed := run.FailureOutput(t.Name)
+		if !failed {
+			continue
+		}
+		if i, ok := idx[output]; ok {
+			res[i].Count += 1
+		} else {
+			idx[output] = len(res)
+			res = append(res, DistinctFailure{Output: output, Count: 1})
+		}
+	}
+	sort.SliceStable(res, func(a, b int) bool {
+		return res[a].Count > res[b].Count
+	})
+	return res
+}
+
+// The fraction of the runs of a test which failed, were fatal or timed out.
+func (s TestSummary) FailureRate() float64 {
+	if s.Runs() == 0 {
+		return 0
+	}
+	return float64(s.Failed+s.Fatal+s.TimedOut) / float64(s.Runs())
+}
+
+type FlakinessReport struct {
+	Time  time.Time             `json:"time"`
+	Tests []FlakinessReportTest `json:"tests"`
+}
+
+type FlakinessReportTest struct {
+	File        string            `json:"file"`
+	Test        string            `json:"test"`
+	Runs        int               `json:"runs"`
+	Passed      int               `json:"passed"`
+	Failed      int               `json:"failed"`
+	Fatal       int               `json:"fatal"`
+	TimedOut    int               `json:"timed_out"`
+	Skipped     int               `json:"skipped"`
+	FailureRate float64           `json:"failure_rate"`
+	Failures    []DistinctFailure `json:"failures,omitempty"`
+}
+
+func NewFlakinessReport(files []TestFile) FlakinessReport {
+	res := FlakinessReport{Time: time.Now().UTC(), Tests: []FlakinessReportTest{}}
+	for _, f := range files {
+		for _, t := range f.Tests {
+			s := t.Summary()
+			res.Tests = append(res.Tests, FlakinessReportTest{
+				File:        f.Name,
+				Test:        t.Name,
+				Runs:        s.Runs(),
+				Passed:      s.Passed,
+				Failed:      s.Failed,
+				Fatal:       s.Fatal,
+				TimedOut:    s.TimedOut,
+				Skipped:     s.Skipped,
+				FailureRate: s.FailureRate(),
+				Failures:    t.DistinctFailures(),
+			})
+		}
+	}
+	return res
+}
+
+// Write a machine-readable version of the flakiness of every test to
+// |path|, so it can be tracked over time.
+func WriteFlakinessReport(path string, files []TestFile) error {
+	bs, err := json.MarshalIndent(NewFlakinessReport(files), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, bs, 0644)
+}
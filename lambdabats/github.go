@@ -0,0 +1,194 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Where the bats tests live relative to the root of the dolt repository,
+// which is what GitHub Actions resolves annotation paths against.
+const githubBatsDir = "integration-tests/bats/"
+
+// Matches the location bats reports for a failure, for example "(in test
+// file sql.bats, line 596)", or the tail of "(from function `f' in file
+// helper/common.bash, line 12, in test file sql.bats, line 596)".
+var testFileLineRegexp = regexp.MustCompile(`in test file ([^,()]+), line (\d+)\)`)
+
+// The line of the test file which the failure |output| was reported at, or 0
+// if bats did not report one.
+func failureLine(fileName, output string) int {
+	for _, m := range testFileLineRegexp.FindAllStringSubmatch(output, -1) {
+		if m[1] != fileName {
+			continue
+		}
+		if n, err := strconv.Atoi(m[2]); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// Escaping for the message of a workflow command.
+func githubEscapeData(s string) string {
+	s = strings.ReplaceAll(s, "%", "%25")
+	s = strings.ReplaceAll(s, "\r", "%0D")
+	return strings.ReplaceAll(s, "\n", "%0A")
+}
+
+// Escaping for the properties of a workflow command, like file and title.
+func githubEscapeProperty(s string) string {
+	s = githubEscapeData(s)
+	s = strings.ReplaceAll(s, ":", "%3A")
+	return strings.ReplaceAll(s, ",", "%2C")
+}
+
+// Write an ::error workflow command for each failing test, pointing at the
+// line of the bats file it failed at. Flaky and quarantined tests get a
+// ::warning instead when they do not fail the run.
+func WriteGitHubAnnotations(w io.Writer, files []TestFile, info RunInfo) error {
+	for _, f := range files {
+		for _, t := range f.Tests {
+			s := t.Summary()
+			if !s.Failing() {
+				continue
+			}
+			level := "error"
+			if !info.FailsRun(t, s) {
+				level = "warning"
+			}
+			output := failureOutput(t, s)
+			props := "file=" + githubEscapeProperty(githubBatsDir+f.Name)
+			if line := failureLine(f.Name, strings.Join(output, "\n")); line != 0 {
+				props += fmt.Sprintf(",line=%d", line)
+			}
+			props += ",title=" + githubEscapeProperty(t.Name)
+			msg := output
+			if note := runsNote(s); note != "" {
+				msg = append([]string{note}, msg...)
+			}
+			_, err := fmt.Fprintf(w, "::%s %s::%s\n", level, props, githubEscapeData(strings.TrimSpace(strings.Join(msg, "\n"))))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func markdownEscape(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// Write a Markdown summary of the results, with the totals and a table of
+// the tests which did not pass.
+func WriteGitHubStepSummary(w io.Writer, files []TestFile, info RunInfo) error {
+	var passed, failed, fatal, flaky, skipped, notRun int
+	var rows []string
+	for _, f := range files {
+		for _, t := range f.Tests {
+			s := t.Summary()
+			switch s.Status {
+			case TestStatus_Passed:
+				passed += 1
+			case TestStatus_Skipped:
+				skipped += 1
+			case TestStatus_Failed, TestStatus_TimedOut:
+				failed += 1
+			case TestStatus_Fatal:
+				fatal += 1
+			case TestStatus_Flaky:
+				flaky += 1
+			case TestStatus_NotRun:
+				notRun += 1
+			}
+			if !s.Failing() {
+				continue
+			}
+			location := f.Name
+			if line := failureLine(f.Name, strings.Join(failureOutput(t, s), "\n")); line != 0 {
+				location = fmt.Sprintf("%s:%d", f.Name, line)
+			}
+			status := s.Status.String()
+			if note := runsNote(s); note != "" {
+				status = note
+			}
+			if t.Quarantine != nil {
+				status += " (quarantined)"
+			}
+			rows = append(rows, fmt.Sprintf("| %s | %s | %s |", markdownEscape(location), markdownEscape(t.Name), status))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("## lambdabats results\n\n")
+	b.WriteString("| Tests | Passed | Failed | Fatal | Flaky | Skipped | Not run |\n")
+	b.WriteString("| ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d | %d | %d | %d |\n", passed+failed+fatal+flaky+skipped+notRun, passed, failed, fatal, flaky, skipped, notRun)
+	if info.Focus {
+		fmt.Fprintf(&b, "\n**%s**\n", focusWarning)
+	}
+	if warning := info.StoppedWarning(); warning != "" {
+		fmt.Fprintf(&b, "\n**%s** %d tests were not run.\n", warning, notRun)
+	}
+	if len(rows) > 0 {
+		b.WriteString("\n| File | Test | Status |\n")
+		b.WriteString("| --- | --- | --- |\n")
+		for _, row := range rows {
+			b.WriteString(row + "\n")
+		}
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Outputs the normal bats results, along with annotations for GitHub
+// Actions. If $GITHUB_STEP_SUMMARY is set, a summary of the results is
+// appended to it.
+func OutputGitHubResults(files []TestFile, info RunInfo) int {
+	res := OutputBatsResults(files, info)
+	err := WriteGitHubAnnotations(os.Stdout, files, info)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing github annotations: %v\n", err)
+		res = 1
+	}
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		err = appendGitHubStepSummary(path, files, info)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing github step summary to %s: %v\n", path, err)
+		}
+	}
+	return res
+}
+
+func appendGitHubStepSummary(path string, files []TestFile, info RunInfo) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = WriteGitHubStepSummary(f, files, info)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestFailureLine(t *testing.T) {
+	assert.Equal(t, 596, failureLine("sql.bats", "   (in test file sql.bats, line 596)\n     `[ $status -eq 0 ]' failed"))
+	assert.Equal(t, 596, failureLine("sql.bats", "   (from function `f' in file helper/common.bash, line 12,\n    in test file sql.bats, line 596)"))
+	assert.Equal(t, 0, failureLine("sql.bats", "   (in test file other.bats, line 3)"))
+	assert.Equal(t, 0, failureLine("sql.bats", "lambda function error: Unhandled"))
+}
+
+func TestGitHubResults(t *testing.T) {
+	file := TestFile{Name: "sql.bats"}
+	failed := TestRun{Response: wire.RunTestResult{Err: "exit status 1", Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0.25">
+<testsuite name="sql.bats" tests="1" failures="1" errors="0" skipped="0" time="0.25">
+    <testcase classname="sql.bats" name="sql: fails, 100%" time="0.25">
+        <failure type="failure">(in test file sql.bats, line 596)</failure>
+    </testcase>
+</testsuite>
+</testsuites>
+`}}
+	fatal := TestRun{Response: wire.RunTestResult{Err: "lambda function error: Unhandled"}}
+	files := []TestFile{{Name: "sql.bats", Tests: []Test{
+		{Name: "sql: fails, 100%", File: file, Runs: []TestRun{failed}},
+		{Name: "sql: fatal | pipe", File: file, Runs: []TestRun{fatal}},
+		{Name: "sql: not run", File: file},
+	}}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteGitHubAnnotations(&buf, files, RunInfo{}))
+	assert.Equal(t, "::error file=integration-tests/bats/sql.bats,line=596,title=sql%3A fails%2C 100%25::(in test file sql.bats, line 596)\n"+
+		"::error file=integration-tests/bats/sql.bats,title=sql%3A fatal | pipe::lambda function error: Unhandled\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, WriteGitHubStepSummary(&buf, files, RunInfo{}))
+	assert.Contains(t, buf.String(), "| 3 | 0 | 1 | 1 | 0 | 0 | 1 |\n")
+	assert.Contains(t, buf.String(), "| sql.bats:596 | sql: fails, 100% | failed |\n")
+	assert.Contains(t, buf.String(), `| sql.bats | sql: fatal \| pipe | fatal |`)
+}
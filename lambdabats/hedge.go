@@ -0,0 +1,234 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Hedges against straggling test runs. When a test has been running for
+// much longer than we expect it to take, the Hedger starts a duplicate
+// invocation of it and takes whichever finishes first.
+//
+// How long we expect a test to take comes from how long it took the last
+// time we ran it, if we know, and otherwise from a percentile of how long
+// the tests which have already finished in this run took.
+//
+// Canceling the losing invocation only stops us waiting on it. The Lambda
+// function keeps running it, and we keep being billed for it, until the test
+// finishes or the function times out. Since the loser's slot is freed right
+// away, the concurrency limit is only approximate while hedging.
+type Hedger struct {
+	// Hedge after a test has been running for this many times longer than
+	// expected.
+	factor float64
+	// The percentile of the durations in this run to use for tests with
+	// no history.
+	percentile float64
+	// Never hedge before this much time has passed.
+	minDelay time.Duration
+	// Don't use the durations from this run until we have this many.
+	minSamples int
+	// How often to check whether an invocation should be hedged.
+	interval time.Duration
+
+	history map[string]time.Duration
+
+	mu        sync.Mutex
+	durations map[string]time.Duration
+	samples   []time.Duration
+	// Cached percentile of samples, valid when len(samples) == cachedAt.
+	cached   time.Duration
+	cachedAt int
+}
+
+func NewHedger(factor float64, history map[string]time.Duration) *Hedger {
+	return &Hedger{
+		factor:     factor,
+		percentile: 0.95,
+		minDelay:   5 * time.Second,
+		minSamples: 20,
+		interval:   1 * time.Second,
+		history:    history,
+		durations:  make(map[string]time.Duration),
+	}
+}
+
+func HedgeKey(t *Test) string {
+	return t.File.Name + "\x00" + t.Name
+}
+
+// How long to let the test identified by |key| run before hedging it.
+// Returns false if we do not know yet.
+func (h *Hedger) threshold(key string) (time.Duration, bool) {
+	if d, ok := h.history[key]; ok {
+		return max(time.Duration(h.factor*float64(d)), h.minDelay), true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < h.minSamples {
+		return 0, false
+	}
+	if h.cachedAt != len(h.samples) {
+		sorted := slices.Clone(h.samples)
+		slices.Sort(sorted)
+		h.cached = sorted[int(h.percentile*float64(len(sorted)-1))]
+		h.cachedAt = len(h.samples)
+	}
+	return max(time.Duration(h.factor*float64(h.cached)), h.minDelay), true
+}
+
+func (h *Hedger) record(key string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.durations[key] = d
+	h.samples = append(h.samples, d)
+}
+
+type hedgeResult struct {
+	run      TestRun
+	err      error
+	hedge    bool
+	duration time.Duration
+}
+
+// Run the test identified by |key| with |run|, hedging it with a second
+// call to |run| if it takes too long. The loser's context is canceled.
+//
+// The hedge takes up a slot in |slots| for as long as we wait on it, the
+// same as the tests the Dispatcher starts, so that hedging does not start
+// more invocations than the dispatcher's concurrency allows. If no slot is
+// free, the hedge waits for one. A nil |slots| does not limit hedges.
+func (h *Hedger) Run(ctx context.Context, key string, slots chan struct{}, run func(context.Context) (TestRun, error)) (TestRun, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	start := func(hedge bool) {
+		go func() {
+			if hedge && slots != nil {
+				defer func() { <-slots }()
+			}
+			begin := time.Now()
+			res, err := run(ctx)
+			results <- hedgeResult{res, err, hedge, time.Since(begin)}
+		}()
+	}
+	start(false)
+	begin := time.Now()
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	hedged := false
+	pending := 1
+	var first *hedgeResult
+	for {
+		select {
+		case res := <-results:
+			pending -= 1
+			if res.err != nil && pending > 0 {
+				// Give the other invocation a chance.
+				first = &res
+				continue
+			}
+			if res.err != nil {
+				if first != nil {
+					return first.run, first.err
+				}
+				return res.run, res.err
+			}
+			h.record(key, res.duration)
+			res.run.Hedged = hedged
+			res.run.HedgeWon = res.hedge
+			return res.run, nil
+		case <-ticker.C:
+			if hedged {
+				continue
+			}
+			if limit, ok := h.threshold(key); ok && time.Since(begin) > limit {
+				if slots != nil {
+					select {
+					case slots <- struct{}{}:
+					default:
+						// Try again on the next tick.
+						continue
+					}
+				}
+				hedged = true
+				pending += 1
+				start(true)
+			}
+		}
+	}
+}
+
+func testDurationsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".lambdabats", "durations.json"), nil
+}
+
+// Load how long each test took the last time it was run, keyed by HedgeKey.
+func LoadTestDurations() (map[string]time.Duration, error) {
+	path, err := testDurationsPath()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]time.Duration)
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return res, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bs, &res)
+	return res, err
+}
+
+// Save the durations of the tests run through the Hedger, along with the
+// ones we already knew about, for next time.
+func (h *Hedger) SaveTestDurations() error {
+	path, err := testDurationsPath()
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	merged := make(map[string]time.Duration)
+	for k, v := range h.history {
+		merged[k] = v
+	}
+	for k, v := range h.durations {
+		merged[k] = v
+	}
+	h.mu.Unlock()
+	bs, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, bs, 0644)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedger(t *testing.T) {
+	h := NewHedger(2, map[string]time.Duration{"slow": 10 * time.Millisecond})
+	h.minDelay = 0
+	h.interval = time.Millisecond
+
+	// The first invocation hangs until it is canceled, so the hedge wins.
+	var calls atomic.Int32
+	run, err := h.Run(context.Background(), "slow", nil, func(ctx context.Context) (TestRun, error) {
+		if calls.Add(1) == 1 {
+			<-ctx.Done()
+			return TestRun{}, ctx.Err()
+		}
+		return TestRun{}, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, run.Hedged)
+	assert.True(t, run.HedgeWon)
+
+	// Without any history or samples, we never hedge.
+	run, err = h.Run(context.Background(), "unknown", nil, func(ctx context.Context) (TestRun, error) {
+		time.Sleep(20 * time.Millisecond)
+		return TestRun{}, nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, run.Hedged)
+}
+
+func TestHedgerSlots(t *testing.T) {
+	h := NewHedger(2, map[string]time.Duration{"slow": time.Millisecond})
+	h.minDelay = 0
+	h.interval = time.Millisecond
+
+	// Every slot is taken, so the slow invocation is not hedged.
+	slots := make(chan struct{}, 1)
+	slots <- struct{}{}
+	run, err := h.Run(context.Background(), "slow", slots, func(ctx context.Context) (TestRun, error) {
+		time.Sleep(20 * time.Millisecond)
+		return TestRun{}, nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, run.Hedged)
+
+	// Once a slot is free, the hedge takes it until it finishes.
+	<-slots
+	var calls atomic.Int32
+	run, err = h.Run(context.Background(), "slow", slots, func(ctx context.Context) (TestRun, error) {
+		if calls.Add(1) == 1 {
+			<-ctx.Done()
+			return TestRun{}, ctx.Err()
+		}
+		assert.Len(t, slots, 1)
+		return TestRun{}, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, run.HedgeWon)
+	assert.Eventually(t, func() bool { return len(slots) == 0 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,440 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The record of one lambdabats run which is kept in the history store.
+type HistoryRun struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	BatsDir string    `json:"bats_dir"`
+	// The commit the dolt checkout was at, with a "-dirty" suffix if it
+	// had uncommitted changes. Empty if it could not be determined.
+	DoltCommit string        `json:"dolt_commit"`
+	Artifacts  JSONArtifacts `json:"artifacts"`
+	// Hashes of the sources the artifacts were built from, so that a rerun
+	// can tell whether it can reuse them.
+	Sources  SourceHashes  `json:"sources"`
+	Strategy string        `json:"strategy"`
+	EnvVars  []string      `json:"env_vars"`
+	Arch     string        `json:"arch"`
+	Race     bool          `json:"race"`
+	Tests    []HistoryTest `json:"tests"`
+}
+
+type HistoryTest struct {
+	File string   `json:"file"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags,omitempty"`
+	// One of passed, skipped, failed, flaky, fatal, timeout or not_run.
+	Status     string `json:"status"`
+	Runs       int    `json:"runs"`
+	DurationMS int64  `json:"duration_ms"`
+	// A digest of the failure output, so that runs which failed the same
+	// way can be told apart from ones which failed differently without
+	// keeping all the output around.
+	OutputDigest string `json:"output_digest,omitempty"`
+}
+
+func (r HistoryRun) Counts() map[string]int {
+	res := make(map[string]int)
+	for _, t := range r.Tests {
+		res[t.Status] += 1
+	}
+	return res
+}
+
+// Run IDs start with the time of the run in UTC, so that sorting them sorts
+// the runs oldest first.
+func newHistoryRunID(now time.Time) string {
+	return now.UTC().Format("20060102-150405") + "-" + uuid.NewString()[:8]
+}
+
+func outputDigest(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	h := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(h[:8])
+}
+
+// The commit |doltSrcDir| is checked out at, or "" if it is not a git
+// checkout.
+func DoltCommit(doltSrcDir string) string {
+	out, err := exec.Command("git", "-C", doltSrcDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	commit := strings.TrimSpace(string(out))
+	out, err = exec.Command("git", "-C", doltSrcDir, "status", "--porcelain").Output()
+	if err == nil && len(bytes.TrimSpace(out)) > 0 {
+		commit += "-dirty"
+	}
+	return commit
+}
+
+// How the tests in a run were built and run, as recorded in the history.
+type HistoryRunConfig struct {
+	BatsDir    string
+	DoltCommit string
+	Sources    SourceHashes
+	Strategy   string
+	Arch       string
+	Race       bool
+}
+
+func NewHistoryRun(now time.Time, config HistoryRunConfig, files []TestFile, info RunInfo) HistoryRun {
+	run := HistoryRun{
+		ID:         newHistoryRunID(now),
+		Time:       now.UTC(),
+		BatsDir:    config.BatsDir,
+		DoltCommit: config.DoltCommit,
+		Artifacts:  NewJSONArtifacts(info.Artifacts),
+		Sources:    config.Sources,
+		Strategy:   config.Strategy,
+		EnvVars:    EnvVars,
+		Arch:       config.Arch,
+		Race:       config.Race,
+		Tests:      []HistoryTest{},
+	}
+	for _, f := range files {
+		for _, t := range f.Tests {
+			s := t.Summary()
+			run.Tests = append(run.Tests, HistoryTest{
+				File:         f.Name,
+				Name:         t.Name,
+				Tags:         t.Tags,
+				Status:       s.Status.String(),
+				Runs:         s.Runs(),
+				DurationMS:   t.MeanDuration().Milliseconds(),
+				OutputDigest: outputDigest(failureOutput(t, s)),
+			})
+		}
+	}
+	return run
+}
+
+func historyDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".lambdabats", "history"), nil
+}
+
+// The most runs kept in the history store. The oldest ones are removed when
+// a new run is saved.
+const maxHistoryRuns = 200
+
+// Save |run| in the history store, as a JSON file named after its ID in
+// ~/.lambdabats/history, and remove the oldest runs beyond maxHistoryRuns.
+func SaveHistoryRun(run HistoryRun) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that a concurrent reader never
+	// sees a partial record.
+	tmp := filepath.Join(dir, "."+run.ID+".json.tmp")
+	err = os.WriteFile(tmp, bs, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, filepath.Join(dir, run.ID+".json"))
+	if err != nil {
+		return err
+	}
+	ids, err := historyRunIDs(dir)
+	if err != nil {
+		return err
+	}
+	for len(ids) > maxHistoryRuns {
+		err = os.Remove(filepath.Join(dir, ids[0]+".json"))
+		if err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// The IDs of the runs in the history store in |dir|, oldest first.
+func historyRunIDs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func LoadHistoryRun(id string) (HistoryRun, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return HistoryRun{}, err
+	}
+	var run HistoryRun
+	bs, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return run, fmt.Errorf("no run with id %s in the history", id)
+	} else if err != nil {
+		return run, err
+	}
+	err = json.Unmarshal(bs, &run)
+	return run, err
+}
+
+// Load the last |n| runs in the history store, or all of them if |n| is 0,
+// oldest first. Records which cannot be read, for example because they were
+// corrupted, are skipped with a warning instead of failing the whole load.
+func LoadHistoryRuns(n int) ([]HistoryRun, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := historyRunIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && len(ids) > n {
+		ids = ids[len(ids)-n:]
+	}
+	var runs []HistoryRun
+	for _, id := range ids {
+		run, err := LoadHistoryRun(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping unreadable history record %s.json: %v\n", id, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(a, b int) bool {
+		return runs[a].Time.Before(runs[b].Time)
+	})
+	return runs, nil
+}
+
+// The status of one test in one run.
+type TimelineEntry struct {
+	Run  HistoryRun
+	Test HistoryTest
+}
+
+// The runs |file| |name| was part of, oldest first.
+func TestTimeline(runs []HistoryRun, file, name string) []TimelineEntry {
+	var res []TimelineEntry
+	for _, run := range runs {
+		for _, t := range run.Tests {
+			if t.File == file && t.Name == name {
+				res = append(res, TimelineEntry{run, t})
+				break
+			}
+		}
+	}
+	return res
+}
+
+// How often a test failed across the runs in the history.
+type FlakeRate struct {
+	File string
+	Name string
+	// The runs which ran the test and did not skip it.
+	Runs int
+	// The runs in which the test failed, including the ones where it also
+	// passed.
+	Failed int
+	// The number of distinct failure outputs.
+	Failures int
+}
+
+func (f FlakeRate) Rate() float64 {
+	if f.Runs == 0 {
+		return 0
+	}
+	return float64(f.Failed) / float64(f.Runs)
+}
+
+// The flake rate of every test which failed at least once in |runs|, worst
+// first. A test which fails every time is broken rather than flaky, so tests
+// which never passed are left out.
+func FlakeRates(runs []HistoryRun) []FlakeRate {
+	type key struct{ file, name string }
+	rates := make(map[key]*FlakeRate)
+	passed := make(map[key]bool)
+	digests := make(map[key]map[string]bool)
+	var order []key
+	for _, run := range runs {
+		for _, t := range run.Tests {
+			if t.Status == "skipped" || t.Status == "not_run" {
+				continue
+			}
+			k := key{t.File, t.Name}
+			r, ok := rates[k]
+			if !ok {
+				r = &FlakeRate{File: t.File, Name: t.Name}
+				rates[k] = r
+				digests[k] = make(map[string]bool)
+				order = append(order, k)
+			}
+			r.Runs += 1
+			switch t.Status {
+			case "passed":
+				passed[k] = true
+			case "flaky":
+				passed[k] = true
+				r.Failed += 1
+			default:
+				r.Failed += 1
+			}
+			if t.OutputDigest != "" {
+				digests[k][t.OutputDigest] = true
+			}
+		}
+	}
+	var res []FlakeRate
+	for _, k := range order {
+		r := rates[k]
+		if r.Failed == 0 || !passed[k] {
+			continue
+		}
+		r.Failures = len(digests[k])
+		res = append(res, *r)
+	}
+	sort.SliceStable(res, func(a, b int) bool {
+		return res[a].Rate() > res[b].Rate()
+	})
+	return res
+}
+
+func printHistoryUsage() {
+	fmt.Println("usage: lambda-bats history list [-n RUNS]")
+	fmt.Println("usage: lambda-bats history test [-n RUNS] FILE TEST_NAME")
+	fmt.Println("usage: lambda-bats history flakes [-n RUNS]")
+}
+
+func DoHistory(args []string) int {
+	if len(args) == 0 {
+		printHistoryUsage()
+		return 1
+	}
+	fs := flag.NewFlagSet("history "+args[0], flag.ExitOnError)
+	n := fs.Int("n", 0, "only look at this many of the most recent runs")
+	fs.Parse(args[1:])
+
+	runs, err := LoadHistoryRuns(*n)
+	if err != nil {
+		fmt.Printf("could not load history: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		writeHistoryList(os.Stdout, runs)
+	case "test":
+		if fs.NArg() != 2 {
+			printHistoryUsage()
+			return 1
+		}
+		timeline := TestTimeline(runs, fs.Arg(0), fs.Arg(1))
+		if len(timeline) == 0 {
+			fmt.Printf("%s: %s was not found in the history\n", fs.Arg(0), fs.Arg(1))
+			return 1
+		}
+		writeTestTimeline(os.Stdout, timeline)
+	case "flakes":
+		writeFlakeRates(os.Stdout, FlakeRates(runs), len(runs))
+	default:
+		printHistoryUsage()
+		return 1
+	}
+	return 0
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		dirty := strings.HasSuffix(commit, "-dirty")
+		commit = commit[:12]
+		if dirty {
+			commit += "-dirty"
+		}
+	}
+	if commit == "" {
+		return "unknown"
+	}
+	return commit
+}
+
+func writeHistoryList(w io.Writer, runs []HistoryRun) {
+	for _, run := range runs {
+		c := run.Counts()
+		fmt.Fprintf(w, "%s  %s  %-18s  %d tests, %d failures, %d fatal, %d flaky, %d skipped\n",
+			run.ID, run.Time.Local().Format("2006-01-02 15:04"), shortCommit(run.DoltCommit),
+			len(run.Tests), c["failed"], c["fatal"], c["flaky"], c["skipped"])
+	}
+}
+
+func writeTestTimeline(w io.Writer, timeline []TimelineEntry) {
+	for _, e := range timeline {
+		fmt.Fprintf(w, "%s  %s  %-18s  %-7s  %8.2fs  %s\n",
+			e.Run.ID, e.Run.Time.Local().Format("2006-01-02 15:04"), shortCommit(e.Run.DoltCommit),
+			e.Test.Status, time.Duration(e.Test.DurationMS*int64(time.Millisecond)).Seconds(), e.Test.OutputDigest)
+	}
+}
+
+func writeFlakeRates(w io.Writer, rates []FlakeRate, numRuns int) {
+	if len(rates) == 0 {
+		fmt.Fprintf(w, "no flaky tests in the last %d runs\n", numRuns)
+		return
+	}
+	for _, r := range rates {
+		fmt.Fprintf(w, "%5.1f%%  %d/%d runs failed, %d distinct failures  %s: %s\n",
+			100*r.Rate(), r.Failed, r.Runs, r.Failures, r.File, r.Name)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestHistoryStore(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	runs, err := LoadHistoryRuns(0)
+	assert.NoError(t, err)
+	assert.Empty(t, runs)
+
+	file := TestFile{Name: "a.bats"}
+	passedRun := TestRun{Response: wire.RunTestResult{Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0">
+<testsuite name="a.bats" tests="1" failures="0" errors="0" skipped="0" time="0">
+    <testcase classname="a.bats" name="a: passes" time="0" />
+</testsuite>
+</testsuites>
+`}}
+	failedRun := TestRun{Response: wire.RunTestResult{Err: "exit status 1", Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0">
+<testsuite name="a.bats" tests="1" failures="1" errors="0" skipped="0" time="0">
+    <testcase classname="a.bats" name="a: fails" time="0">
+        <failure type="failure">(in test file a.bats, line 3)</failure>
+    </testcase>
+</testsuite>
+</testsuites>
+`}}
+	files := []TestFile{{Name: "a.bats", Tests: []Test{
+		{Name: "a: passes", File: file, Runs: []TestRun{passedRun}},
+		{Name: "a: fails", File: file, Tags: []string{"slow"}, Runs: []TestRun{failedRun}},
+	}}}
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	later := HistoryRun{ID: "later", Time: now.Add(time.Hour)}
+	assert.NoError(t, SaveHistoryRun(later))
+	config := HistoryRunConfig{BatsDir: "/dolt/integration-tests/bats", DoltCommit: "abc123", Arch: "arm64", Race: true}
+	run := NewHistoryRun(now, config, files, RunInfo{Artifacts: UploadLocations{DoltPath: "DOLT"}})
+	assert.NoError(t, SaveHistoryRun(run))
+
+	runs, err = LoadHistoryRuns(0)
+	assert.NoError(t, err)
+	if assert.Len(t, runs, 2) {
+		assert.Equal(t, run.ID, runs[0].ID)
+		assert.Equal(t, "later", runs[1].ID)
+		assert.Equal(t, "DOLT", runs[0].Artifacts.Dolt)
+		assert.True(t, runs[0].Race)
+		if assert.Len(t, runs[0].Tests, 2) {
+			assert.Equal(t, HistoryTest{File: "a.bats", Name: "a: passes", Status: "passed", Runs: 1}, runs[0].Tests[0])
+			assert.Equal(t, "failed", runs[0].Tests[1].Status)
+			assert.Equal(t, []string{"slow"}, runs[0].Tests[1].Tags)
+			assert.NotEmpty(t, runs[0].Tests[1].OutputDigest)
+		}
+	}
+
+	_, err = LoadHistoryRun("missing")
+	assert.Error(t, err)
+
+	last, err := LoadLastHistoryRun()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "later", last.ID)
+	}
+}
+
+func TestHistoryStoreCorruptRecords(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	_, err := LoadLastHistoryRun()
+	assert.ErrorIs(t, err, ErrNoRecordedRun)
+
+	assert.NoError(t, SaveHistoryRun(HistoryRun{ID: "20231001-120000-aaaaaaaa"}))
+	assert.NoError(t, SaveHistoryRun(HistoryRun{ID: "20231001-130000-bbbbbbbb"}))
+	dir, err := historyDir()
+	if !assert.NoError(t, err) {
+		return
+	}
+	// A record which was cut short.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "20231001-140000-cccccccc.json"), []byte(`{"id": "20231001-1`), 0644))
+
+	runs, err := LoadHistoryRuns(0)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	last, err := LoadLastHistoryRun()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "20231001-130000-bbbbbbbb", last.ID)
+	}
+	runs, err = LoadHistoryRuns(2)
+	assert.NoError(t, err)
+	if assert.Len(t, runs, 1) {
+		assert.Equal(t, "20231001-130000-bbbbbbbb", runs[0].ID)
+	}
+}
+
+func TestHistoryStoreRetention(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	id := func(i int) string {
+		return fmt.Sprintf("20231001-%06d-aaaaaaaa", i)
+	}
+	for i := range maxHistoryRuns {
+		assert.NoError(t, SaveHistoryRun(HistoryRun{ID: id(i)}))
+	}
+	assert.NoError(t, SaveHistoryRun(HistoryRun{ID: id(maxHistoryRuns)}))
+	runs, err := LoadHistoryRuns(0)
+	assert.NoError(t, err)
+	if assert.Len(t, runs, maxHistoryRuns) {
+		assert.Equal(t, id(1), runs[0].ID)
+		assert.Equal(t, id(maxHistoryRuns), runs[len(runs)-1].ID)
+	}
+}
+
+func TestFlakeRates(t *testing.T) {
+	run := func(statuses ...string) HistoryRun {
+		var r HistoryRun
+		for i, s := range statuses {
+			t := HistoryTest{File: "a.bats", Name: string(rune('a' + i)), Status: s}
+			if s != "passed" && s != "skipped" {
+				t.OutputDigest = "digest " + s
+			}
+			r.Tests = append(r.Tests, t)
+		}
+		return r
+	}
+	runs := []HistoryRun{
+		run("passed", "failed", "failed", "skipped"),
+		run("passed", "passed", "failed", "passed"),
+		run("passed", "flaky", "fatal", "skipped"),
+		run("passed", "passed", "failed", "failed"),
+	}
+	// c never passed, so it is broken rather than flaky.
+	rates := FlakeRates(runs)
+	if assert.Len(t, rates, 2) {
+		assert.Equal(t, FlakeRate{File: "a.bats", Name: "b", Runs: 4, Failed: 2, Failures: 2}, rates[0])
+		assert.Equal(t, 0.5, rates[0].Rate())
+		// The runs where it was skipped are not counted.
+		assert.Equal(t, FlakeRate{File: "a.bats", Name: "d", Runs: 2, Failed: 1, Failures: 1}, rates[1])
+	}
+
+	timeline := TestTimeline(runs, "a.bats", "c")
+	if assert.Len(t, timeline, 4) {
+		assert.Equal(t, "fatal", timeline[2].Test.Status)
+	}
+	assert.Empty(t, TestTimeline(runs, "a.bats", "missing"))
+}
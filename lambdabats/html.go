@@ -0,0 +1,357 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The state of the text attributes set by ANSI SGR escape sequences.
+type ansiStyle struct {
+	fg, bg                  string
+	bold, italic, underline bool
+}
+
+func (s ansiStyle) css() string {
+	var parts []string
+	if s.fg != "" {
+		parts = append(parts, "color:"+s.fg)
+	}
+	if s.bg != "" {
+		parts = append(parts, "background-color:"+s.bg)
+	}
+	if s.bold {
+		parts = append(parts, "font-weight:bold")
+	}
+	if s.italic {
+		parts = append(parts, "font-style:italic")
+	}
+	if s.underline {
+		parts = append(parts, "text-decoration:underline")
+	}
+	return strings.Join(parts, ";")
+}
+
+var ansiColors = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+// The color for |n| in the xterm 256 color palette.
+func ansi256Color(n int) string {
+	switch {
+	case n < 16:
+		return ansiColors[n]
+	case n < 232:
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	case n < 256:
+		v := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", v, v, v)
+	}
+	return ""
+}
+
+// Apply the parameters of an SGR sequence to |s|.
+func (s ansiStyle) apply(params []int) ansiStyle {
+	if len(params) == 0 {
+		return ansiStyle{}
+	}
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		switch {
+		case p == 0:
+			s = ansiStyle{}
+		case p == 1:
+			s.bold = true
+		case p == 3:
+			s.italic = true
+		case p == 4:
+			s.underline = true
+		case p == 22:
+			s.bold = false
+		case p == 23:
+			s.italic = false
+		case p == 24:
+			s.underline = false
+		case p >= 30 && p <= 37:
+			s.fg = ansiColors[p-30]
+		case p == 39:
+			s.fg = ""
+		case p >= 40 && p <= 47:
+			s.bg = ansiColors[p-40]
+		case p == 49:
+			s.bg = ""
+		case p >= 90 && p <= 97:
+			s.fg = ansiColors[p-90+8]
+		case p >= 100 && p <= 107:
+			s.bg = ansiColors[p-100+8]
+		case p == 38 || p == 48:
+			var c string
+			if i+2 < len(params) && params[i+1] == 5 {
+				c = ansi256Color(params[i+2])
+				i += 2
+			} else if i+4 < len(params) && params[i+1] == 2 {
+				c = fmt.Sprintf("#%02x%02x%02x", params[i+2]&0xff, params[i+3]&0xff, params[i+4]&0xff)
+				i += 4
+			}
+			if p == 38 {
+				s.fg = c
+			} else {
+				s.bg = c
+			}
+		}
+	}
+	return s
+}
+
+// Render terminal output as HTML, turning ANSI color escape sequences into
+// styled spans and dropping the other control sequences, like the ones which
+// erase lines.
+//
+// TestRun.Result replaces the ESC which introduces the sequences with U+FFFD,
+// since it cannot appear in XML, so that is recognized here as well.
+func ansiToHTML(output string) template.HTML {
+	var b strings.Builder
+	var style ansiStyle
+	open := false
+	for len(output) > 0 {
+		i := strings.IndexAny(output, "\x1b\ufffd")
+		if i == -1 {
+			b.WriteString(html.EscapeString(output))
+			break
+		}
+		b.WriteString(html.EscapeString(output[:i]))
+		output = output[i:]
+		if output[0] == '\x1b' {
+			output = output[1:]
+		} else {
+			output = output[len("\ufffd"):]
+		}
+		if !strings.HasPrefix(output, "[") {
+			// Not a control sequence we know about.
+			b.WriteString("\ufffd")
+			continue
+		}
+		// A CSI sequence is parameter bytes followed by one final byte.
+		end := strings.IndexFunc(output[1:], func(r rune) bool {
+			return r >= 0x40 && r <= 0x7e
+		})
+		if end == -1 {
+			b.WriteString("\ufffd")
+			continue
+		}
+		params, final := output[1:end+1], output[end+1]
+		output = output[end+2:]
+		if final != 'm' {
+			continue
+		}
+		var nums []int
+		for _, p := range strings.Split(params, ";") {
+			n, _ := strconv.Atoi(p)
+			nums = append(nums, n)
+		}
+		style = style.apply(nums)
+		if open {
+			b.WriteString("</span>")
+			open = false
+		}
+		if css := style.css(); css != "" {
+			b.WriteString(`<span style="` + css + `">`)
+			open = true
+		}
+	}
+	if open {
+		b.WriteString("</span>")
+	}
+	return template.HTML(b.String())
+}
+
+type htmlReport struct {
+	Generated time.Time
+	Artifacts UploadLocations
+	Focus     bool
+	Stopped   string
+	Duration  time.Duration
+	Counts    map[string]int
+	Files     []htmlFile
+}
+
+type htmlFile struct {
+	Name     string
+	Failing  bool
+	Duration time.Duration
+	Counts   map[string]int
+	Tests    []htmlTest
+}
+
+type htmlTest struct {
+	Name     string
+	Tags     []string
+	Status   string
+	Note     string
+	Runner   string
+	Duration time.Duration
+	Output   template.HTML
+}
+
+func newHTMLReport(files []TestFile, info RunInfo) htmlReport {
+	report := htmlReport{
+		Generated: time.Now().UTC(),
+		Artifacts: info.Artifacts,
+		Focus:     info.Focus,
+		Stopped:   info.StoppedWarning(),
+		Counts:    make(map[string]int),
+	}
+	for _, f := range files {
+		hf := htmlFile{Name: f.Name, Counts: make(map[string]int)}
+		for _, t := range f.Tests {
+			s := t.Summary()
+			ht := htmlTest{
+				Name:     t.Name,
+				Tags:     t.Tags,
+				Status:   s.Status.String(),
+				Note:     strings.TrimSpace(runsNote(s) + infraRetriesNote(s.InfraRetries, !s.Failing())),
+				Duration: t.MeanDuration(),
+			}
+			if len(t.Runs) > 0 {
+				ht.Runner = t.Runs[0].Runner
+			}
+			if lines := failureOutput(t, s); len(lines) > 0 {
+				ht.Output = ansiToHTML(strings.Join(lines, "\n"))
+			} else if s.Status == TestStatus_Skipped {
+				ht.Output = ansiToHTML(s.SkipReason)
+			}
+			if s.Failing() {
+				hf.Failing = true
+			}
+			hf.Counts[ht.Status] += 1
+			hf.Duration += ht.Duration
+			hf.Tests = append(hf.Tests, ht)
+			report.Counts[ht.Status] += 1
+		}
+		report.Duration += hf.Duration
+		report.Files = append(report.Files, hf)
+	}
+	return report
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"seconds": func(d time.Duration) string {
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>lambdabats results</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #1f2328; }
+h1 { font-size: 1.5em; }
+table.meta td { padding: 0 1em 0 0; }
+code, pre { font-family: ui-monospace, Menlo, Consolas, monospace; }
+pre { background: #1e1e1e; color: #d4d4d4; padding: 0.75em; overflow-x: auto; margin: 0.25em 0 0.75em 2em; }
+details { border: 1px solid #d0d7de; border-radius: 6px; margin: 0.5em 0; padding: 0.25em 0.75em; }
+summary { cursor: pointer; font-weight: 600; }
+.test { margin: 0.25em 0; }
+.test .time, .test .note, .test .runner, .test .tag { color: #656d76; font-size: 0.9em; margin-left: 0.5em; }
+.badge { display: inline-block; min-width: 4.5em; text-align: center; border-radius: 1em; padding: 0 0.5em; font-size: 0.8em; font-weight: 600; color: #fff; }
+.passed { background: #1a7f37; }
+.failed, .fatal, .timeout { background: #cf222e; }
+.flaky { background: #bf8700; }
+.skipped, .not_run { background: #6e7781; }
+.warning { color: #cf222e; font-weight: 600; }
+#search { width: 30em; padding: 0.3em; margin: 1em 0; }
+</style>
+</head>
+<body>
+<h1>lambdabats results</h1>
+<table class="meta">
+<tr><td>Generated</td><td>{{.Generated.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Total test time</td><td>{{seconds .Duration}}</td></tr>
+<tr><td>dolt</td><td><code>{{.Artifacts.DoltPath}}</code></td></tr>
+<tr><td>bin</td><td><code>{{.Artifacts.BinPath}}</code></td></tr>
+<tr><td>tests</td><td><code>{{.Artifacts.TestsPath}}</code></td></tr>
+</table>
+<p>{{range $status, $n := .Counts}}<span class="badge {{$status}}">{{$n}} {{$status}}</span> {{end}}</p>
+{{if .Focus}}<p class="warning">This test run only contains tests tagged <code>bats:focus</code>!</p>{{end}}
+{{with .Stopped}}<p class="warning">{{.}}</p>{{end}}
+<input id="search" type="search" placeholder="Search test names" oninput="search(this.value)">
+{{range .Files}}
+<details class="file"{{if .Failing}} open{{end}}>
+<summary>{{.Name}} {{range $status, $n := .Counts}}<span class="badge {{$status}}">{{$n}} {{$status}}</span> {{end}}<span class="time">{{seconds .Duration}}</span></summary>
+{{range .Tests}}
+<div class="test" data-name="{{.Name}}">
+<span class="badge {{.Status}}">{{.Status}}</span> {{.Name}}
+{{- range .Tags}}<span class="tag">#{{.}}</span>{{end}}
+<span class="time">{{seconds .Duration}}</span>
+{{- if .Runner}}<span class="runner">{{.Runner}}</span>{{end}}
+{{- if .Note}}<span class="note">{{.Note}}</span>{{end}}
+{{- if .Output}}
+<pre>{{.Output}}</pre>
+{{- end}}
+</div>
+{{end}}
+</details>
+{{end}}
+<script>
+function search(query) {
+  query = query.toLowerCase();
+  for (const file of document.querySelectorAll("details.file")) {
+    let matches = 0;
+    for (const test of file.querySelectorAll(".test")) {
+      const match = test.dataset.name.toLowerCase().includes(query);
+      test.style.display = match ? "" : "none";
+      if (match) matches++;
+    }
+    file.style.display = matches > 0 ? "" : "none";
+    if (query !== "") file.open = matches > 0;
+  }
+}
+</script>
+</body>
+</html>
+`))
+
+// Render the results as a single, self-contained HTML page.
+func WriteHTMLReport(w io.Writer, files []TestFile, info RunInfo) error {
+	return htmlReportTemplate.Execute(w, newHTMLReport(files, info))
+}
+
+func WriteHTMLReportFile(path string, files []TestFile, info RunInfo) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = WriteHTMLReport(f, files, info)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
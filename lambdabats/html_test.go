@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestANSIToHTML(t *testing.T) {
+	assert.Equal(t, "plain &lt;text&gt;", string(ansiToHTML("plain <text>")))
+	assert.Equal(t, `<span style="color:#cd3131">red</span> plain`, string(ansiToHTML("\x1b[31mred\x1b[0m plain")))
+	assert.Equal(t, `<span style="color:#0dbc79;font-weight:bold">ok</span>`, string(ansiToHTML("\ufffd[1;32mok")))
+	assert.Equal(t, `<span style="color:#ff8000">x</span>`, string(ansiToHTML("\x1b[38;2;255;128;0mx\x1b[m")))
+	// Control sequences other than SGR are dropped.
+	assert.Equal(t, "ab", string(ansiToHTML("a\x1b[2Kb")))
+	assert.Equal(t, "bad \ufffd char", string(ansiToHTML("bad \ufffd char")))
+}
+
+func TestHTMLReport(t *testing.T) {
+	file := TestFile{Name: "a.bats"}
+	failed := TestRun{Response: wire.RunTestResult{Err: "exit status 1", Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0.25">
+<testsuite name="a.bats" tests="1" failures="1" errors="0" skipped="0" time="0.25">
+    <testcase classname="a.bats" name="a: &lt;fails&gt;" time="0.25">
+        <failure type="failure">&#27;[31merror&#27;[0m (in test file a.bats, line 3)</failure>
+    </testcase>
+</testsuite>
+</testsuites>
+`}}
+	files := []TestFile{{Name: "a.bats", Tests: []Test{
+		{Name: "a: <fails>", File: file, Runs: []TestRun{failed}},
+	}}}
+	var buf bytes.Buffer
+	assert.NoError(t, WriteHTMLReport(&buf, files, RunInfo{Artifacts: UploadLocations{DoltPath: "DOLTHASH"}}))
+	out := buf.String()
+	assert.Contains(t, out, "DOLTHASH")
+	assert.Contains(t, out, `<span style="color:#cd3131">error</span> (in test file a.bats, line 3)`)
+	assert.Contains(t, out, "a: &lt;fails&gt;")
+	assert.Contains(t, out, `<details class="file" open>`)
+	assert.Contains(t, out, "0.25s")
+}
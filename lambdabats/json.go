@@ -0,0 +1,193 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The results of a whole lambdabats run, as output by -F json.
+type JSONResults struct {
+	Artifacts   JSONArtifacts  `json:"artifacts"`
+	Focus       bool           `json:"focus"`
+	Interrupted bool           `json:"interrupted,omitempty"`
+	FailedFast  int            `json:"failed_fast,omitempty"`
+	Files       []JSONTestFile `json:"files"`
+}
+
+type JSONArtifacts struct {
+	Dolt  string `json:"dolt"`
+	Bin   string `json:"bin"`
+	Tests string `json:"tests"`
+}
+
+type JSONTestFile struct {
+	Name  string     `json:"name"`
+	Tests []JSONTest `json:"tests"`
+}
+
+// The results of a single test, as output by -F json, and as each line of -F
+// jsonl.
+type JSONTest struct {
+	File string   `json:"file"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+	// One of passed, skipped, failed, flaky, fatal, timeout or not_run.
+	Status string        `json:"status"`
+	Runs   []JSONTestRun `json:"runs"`
+	// Set if the test is quarantined, in which case its failures do not
+	// fail the run.
+	Quarantine *QuarantineEntry `json:"quarantine,omitempty"`
+
+	// Only set in -F jsonl, so that each line stands on its own.
+	Artifacts *JSONArtifacts `json:"artifacts,omitempty"`
+}
+
+type JSONTestRun struct {
+	// One of passed, skipped, failed, fatal or timeout.
+	Status        string   `json:"status"`
+	SkipReason    string   `json:"skip_reason,omitempty"`
+	FailureOutput string   `json:"failure_output,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	InfraError    bool     `json:"infra_error,omitempty"`
+	DurationMS    int64    `json:"duration_ms"`
+	LatencyMS     int64    `json:"latency_ms"`
+	Runner        string   `json:"runner"`
+	InfraRetries  []string `json:"infra_retries,omitempty"`
+	Hedged        bool     `json:"hedged,omitempty"`
+	HedgeWon      bool     `json:"hedge_won,omitempty"`
+}
+
+func NewJSONTestRun(name string, run TestRun) JSONTestRun {
+	res := JSONTestRun{
+		Runner:       run.Runner,
+		InfraRetries: run.InfraRetries,
+		Hedged:       run.Hedged,
+		HedgeWon:     run.HedgeWon,
+		LatencyMS:    run.Latency.Milliseconds(),
+	}
+	result, err := run.Result(name)
+	if err != nil {
+		res.Status = "fatal"
+		res.Error = err.Error()
+		res.InfraError = run.InfraErr != ""
+		res.FailureOutput = run.Response.Output
+		return res
+	}
+	res.DurationMS = result.Time.Milliseconds()
+	switch result.Status {
+	case TestRunResultStatus_Success:
+		res.Status = "passed"
+	case TestRunResultStatus_Skipped:
+		res.Status = "skipped"
+		res.SkipReason = result.Output
+	case TestRunResultStatus_TimedOut:
+		res.Status = "timeout"
+		res.Error = run.Response.Err
+		res.FailureOutput = run.Response.Output
+	default:
+		res.Status = "failed"
+		res.FailureOutput = result.Output
+	}
+	return res
+}
+
+func NewJSONTest(t Test) JSONTest {
+	res := JSONTest{
+		File:       t.File.Name,
+		Name:       t.Name,
+		Tags:       t.Tags,
+		Status:     t.Summary().Status.String(),
+		Runs:       []JSONTestRun{},
+		Quarantine: t.Quarantine,
+	}
+	if res.Tags == nil {
+		res.Tags = []string{}
+	}
+	for _, run := range t.Runs {
+		res.Runs = append(res.Runs, NewJSONTestRun(t.Name, run))
+	}
+	return res
+}
+
+func NewJSONArtifacts(locations UploadLocations) JSONArtifacts {
+	return JSONArtifacts{
+		Dolt:  locations.DoltPath,
+		Bin:   locations.BinPath,
+		Tests: locations.TestsPath,
+	}
+}
+
+func NewJSONResults(files []TestFile, info RunInfo) JSONResults {
+	res := JSONResults{
+		Artifacts:   NewJSONArtifacts(info.Artifacts),
+		Focus:       info.Focus,
+		Interrupted: info.Interrupted,
+		FailedFast:  info.FailedFast,
+		Files:       []JSONTestFile{},
+	}
+	for _, f := range files {
+		jf := JSONTestFile{Name: f.Name, Tests: []JSONTest{}}
+		for _, t := range f.Tests {
+			jf.Tests = append(jf.Tests, NewJSONTest(t))
+		}
+		res.Files = append(res.Files, jf)
+	}
+	return res
+}
+
+func WriteJSONResults(w io.Writer, files []TestFile, info RunInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(NewJSONResults(files, info))
+}
+
+// Write one line of JSON for each test.
+func WriteJSONLResults(w io.Writer, files []TestFile, info RunInfo) error {
+	artifacts := NewJSONArtifacts(info.Artifacts)
+	enc := json.NewEncoder(w)
+	for _, f := range files {
+		for _, t := range f.Tests {
+			jt := NewJSONTest(t)
+			jt.Artifacts = &artifacts
+			err := enc.Encode(jt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func OutputJSONResults(files []TestFile, info RunInfo) int {
+	err := WriteJSONResults(os.Stdout, files, info)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing json results: %v\n", err)
+		return 1
+	}
+	return ExitCode(files, info)
+}
+
+func OutputJSONLResults(files []TestFile, info RunInfo) int {
+	err := WriteJSONLResults(os.Stdout, files, info)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing jsonl results: %v\n", err)
+		return 1
+	}
+	return ExitCode(files, info)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestJSONResults(t *testing.T) {
+	file := TestFile{Name: "a.bats"}
+	passed := TestRun{Runner: "lambda", Response: wire.RunTestResult{Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="1.5">
+<testsuite name="a.bats" tests="1" failures="0" errors="0" skipped="0" time="1.5">
+    <testcase classname="a.bats" name="a: flaky" time="1.5" />
+</testsuite>
+</testsuites>
+`}}
+	failed := TestRun{Runner: "lambda", InfraRetries: []string{"throttled"}, Response: wire.RunTestResult{Err: "exit status 1", Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0.25">
+<testsuite name="a.bats" tests="1" failures="1" errors="0" skipped="0" time="0.25">
+    <testcase classname="a.bats" name="a: flaky" time="0.25">
+        <failure type="failure">(in test file a.bats, line 3)</failure>
+    </testcase>
+</testsuite>
+</testsuites>
+`}}
+	skipped := TestRun{Runner: "local", Response: wire.RunTestResult{Output: SkippedJUnitTestCaseOutput("a.bats", "a: skips", "no tty")}}
+	fatal := TestRun{Runner: "lambda", Response: wire.RunTestResult{Err: "lambda function error: Unhandled"}}
+	files := []TestFile{
+		{Name: "a.bats", Tests: []Test{
+			{Name: "a: flaky", Tags: []string{"slow"}, File: file, Runs: []TestRun{passed, failed}},
+			{Name: "a: skips", File: file, Runs: []TestRun{skipped}},
+			{Name: "a: fatal", File: file, Runs: []TestRun{fatal}},
+		}},
+	}
+	info := RunInfo{Artifacts: UploadLocations{DoltPath: "s3://b/dolt.tar.gz", BinPath: "s3://b/bin.tar.gz", TestsPath: "s3://b/tests.tar.gz"}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteJSONResults(&buf, files, info))
+	var parsed JSONResults
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &parsed))
+	assert.Equal(t, "s3://b/bin.tar.gz", parsed.Artifacts.Bin)
+	if assert.Len(t, parsed.Files, 1) && assert.Len(t, parsed.Files[0].Tests, 3) {
+		tests := parsed.Files[0].Tests
+		assert.Equal(t, "flaky", tests[0].Status)
+		assert.Equal(t, []string{"slow"}, tests[0].Tags)
+		if assert.Len(t, tests[0].Runs, 2) {
+			assert.Equal(t, JSONTestRun{Status: "passed", DurationMS: 1500, Runner: "lambda"}, tests[0].Runs[0])
+			assert.Equal(t, "failed", tests[0].Runs[1].Status)
+			assert.Contains(t, tests[0].Runs[1].FailureOutput, "line 3")
+			assert.Equal(t, []string{"throttled"}, tests[0].Runs[1].InfraRetries)
+		}
+		assert.Equal(t, "skipped", tests[1].Status)
+		assert.Equal(t, []string{}, tests[1].Tags)
+		assert.Equal(t, "no tty", tests[1].Runs[0].SkipReason)
+		assert.Equal(t, "local", tests[1].Runs[0].Runner)
+		assert.Equal(t, "fatal", tests[2].Status)
+		assert.Contains(t, tests[2].Runs[0].Error, "Unhandled")
+	}
+
+	buf.Reset()
+	assert.NoError(t, WriteJSONLResults(&buf, files, info))
+	scanner := bufio.NewScanner(&buf)
+	var lines []JSONTest
+	for scanner.Scan() {
+		var jt JSONTest
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &jt))
+		lines = append(lines, jt)
+	}
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, "a.bats", lines[2].File)
+		assert.Equal(t, "a: fatal", lines[2].Name)
+		assert.Equal(t, "s3://b/tests.tar.gz", lines[2].Artifacts.Tests)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type JUnitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	TestSuites []JUnitTestSuite `xml:"testsuite"`
+}
+
+type JUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []JUnitTestCase `xml:"testcase"`
+}
+
+type JUnitTestCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Skipped   *JUnitMessage `xml:"skipped"`
+	Failure   *JUnitMessage `xml:"failure"`
+	Error     *JUnitMessage `xml:"error"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type JUnitMessage struct {
+	Type    string `xml:"type,attr,omitempty"`
+	Message string `xml:"message,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// Build the testcase for all the runs of |t|, and return how long the test
+// took. Tests which could not be run to completion, for example because of
+// infrastructure errors, are reported as errors instead of failures. The
+// failures of tests which do not fail the run, like quarantined ones, go in
+// system-out.
+func junitTestCase(t Test, info RunInfo) (JUnitTestCase, time.Duration) {
+	s := t.Summary()
+	tc := JUnitTestCase{
+		Classname: t.File.Name,
+		Name:      t.Name,
+	}
+	var elapsed time.Duration
+	switch s.Status {
+	case TestStatus_NotRun:
+		tc.Skipped = &JUnitMessage{Body: "not run"}
+	case TestStatus_Passed, TestStatus_Skipped:
+		if res, err := t.Runs[0].Result(t.Name); err == nil {
+			elapsed = res.Time
+		}
+		if s.Status == TestStatus_Skipped {
+			tc.Skipped = &JUnitMessage{Body: s.SkipReason}
+		}
+	case TestStatus_Fatal:
+		// The error says why the test could not be run or its result
+		// not be found, which the response's error, such as "exit
+		// status 1", might not.
+		msg := &JUnitMessage{
+			Type:    "error",
+			Message: strings.SplitN(s.FailureErr.Error(), "\n", 2)[0],
+			Body:    strings.Join(failureOutput(t, s), "\n"),
+		}
+		if !info.FailsRun(t, s) {
+			tc.SystemOut = msg.Message + "\n" + msg.Body
+		} else {
+			tc.Error = msg
+		}
+	default:
+		elapsed = s.FailureResult.Time
+		msg := &JUnitMessage{
+			Type:    "failure",
+			Message: runsNote(s),
+			Body:    strings.Join(failureOutput(t, s), "\n"),
+		}
+		if !info.FailsRun(t, s) {
+			tc.SystemOut = msg.Message + "\n" + msg.Body
+		} else {
+			tc.Failure = msg
+		}
+	}
+	tc.Time = junitTime(elapsed)
+	return tc, elapsed
+}
+
+// Merge the results of all the tests into one JUnit document, with a
+// testsuite for each file.
+func NewJUnitTestSuites(files []TestFile, info RunInfo) JUnitTestSuites {
+	var res JUnitTestSuites
+	var total time.Duration
+	for _, f := range files {
+		suite := JUnitTestSuite{Name: f.Name}
+		var suiteTime time.Duration
+		for _, t := range f.Tests {
+			tc, elapsed := junitTestCase(t, info)
+			suite.Tests += 1
+			if tc.Skipped != nil {
+				suite.Skipped += 1
+			}
+			if tc.Failure != nil {
+				suite.Failures += 1
+			}
+			if tc.Error != nil {
+				suite.Errors += 1
+			}
+			suiteTime += elapsed
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+		suite.Time = junitTime(suiteTime)
+		total += suiteTime
+		res.Tests += suite.Tests
+		res.Failures += suite.Failures
+		res.Errors += suite.Errors
+		res.Skipped += suite.Skipped
+		res.TestSuites = append(res.TestSuites, suite)
+	}
+	res.Time = junitTime(total)
+	return res
+}
+
+func WriteJUnitResults(w io.Writer, files []TestFile, info RunInfo) error {
+	bs, err := xml.MarshalIndent(NewJUnitTestSuites(files, info), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
+
+func WriteJUnitResultsFile(path string, files []TestFile, info RunInfo) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = WriteJUnitResults(f, files, info)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func OutputJUnitResults(files []TestFile, info RunInfo) int {
+	err := WriteJUnitResults(os.Stdout, files, info)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing junit results: %v\n", err)
+		return 1
+	}
+	return ExitCode(files, info)
+}
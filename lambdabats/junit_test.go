@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestJUnitResults(t *testing.T) {
+	file := TestFile{Name: "a.bats"}
+	passed := TestRun{Response: wire.RunTestResult{Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="1.5">
+<testsuite name="a.bats" tests="1" failures="0" errors="0" skipped="0" time="1.5">
+    <testcase classname="a.bats" name="a: passes" time="1.5" />
+</testsuite>
+</testsuites>
+`}}
+	failed := TestRun{Response: wire.RunTestResult{Err: "exit status 1", Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0.25">
+<testsuite name="a.bats" tests="1" failures="1" errors="0" skipped="0" time="0.25">
+    <testcase classname="a.bats" name="a: fails" time="0.25">
+        <failure type="failure">(in test file a.bats, line 3)</failure>
+    </testcase>
+</testsuite>
+</testsuites>
+`}}
+	fatal := TestRun{Response: wire.RunTestResult{Err: "lambda function error: Unhandled"}}
+	unparsable := TestRun{Response: wire.RunTestResult{Err: "exit status 1", Output: "bats: command not found"}}
+	skipped := TestRun{Response: wire.RunTestResult{Output: SkippedJUnitTestCaseOutput("b.bats", "b: skips", "no tty")}}
+	files := []TestFile{
+		{Name: "a.bats", Tests: []Test{
+			{Name: "a: passes", File: file, Runs: []TestRun{passed}},
+			{Name: "a: fails", File: file, Runs: []TestRun{failed}},
+			{Name: "a: fatal", File: file, Runs: []TestRun{fatal}},
+			{Name: "a: unparsable", File: file, Runs: []TestRun{unparsable}},
+		}},
+		{Name: "b.bats", Tests: []Test{
+			{Name: "b: skips", File: TestFile{Name: "b.bats"}, Runs: []TestRun{skipped}},
+		}},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteJUnitResults(&buf, files, RunInfo{}))
+	var parsed JUnitTestSuites
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &parsed))
+	assert.Equal(t, 5, parsed.Tests)
+	assert.Equal(t, 1, parsed.Failures)
+	assert.Equal(t, 2, parsed.Errors)
+	assert.Equal(t, 1, parsed.Skipped)
+	assert.Equal(t, "1.750", parsed.Time)
+	if assert.Len(t, parsed.TestSuites, 2) {
+		cases := parsed.TestSuites[0].TestCases
+		assert.Equal(t, "1.500", cases[0].Time)
+		assert.Contains(t, cases[1].Failure.Body, "line 3")
+		assert.Contains(t, cases[2].Error.Message, "Unhandled")
+		// The message is why the result could not be found, and the
+		// output is in the body.
+		assert.Equal(t, "EOF", cases[3].Error.Message)
+		assert.Contains(t, cases[3].Error.Body, "bats: command not found")
+		assert.Equal(t, "no tty", parsed.TestSuites[1].TestCases[0].Skipped.Body)
+	}
+}
@@ -16,38 +16,65 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
-
-	"github.com/schollz/progressbar/v3"
-	"golang.org/x/sync/errgroup"
-
-	"github.com/dolthub/lambdabats/wire"
+	"sync"
+	"time"
 )
 
 const S3BucketName = "dolt-cloud-test-run-artifacts"
 const LambdaFunctionName = "dolt_bats_test_runner"
 
-var OutputResults = OutputBatsResults
+var OutputFormats = map[string]OutputResultsFunc{
+	"pretty": OutputBatsResults,
+	"tap":    OutputTAPResults,
+	"tap13":  OutputTAP13Results,
+	"junit":  OutputJUnitResults,
+	"json":   OutputJSONResults,
+	"jsonl":  OutputJSONLResults,
+	"github": OutputGitHubResults,
+}
 
-var OutputFormat = flag.String("F", "pretty", "format the test results output; either bats pretty format or tap")
+var OutputFormat = flag.String("F", "pretty", "format the test results output; one of bats pretty format, tap, tap13, junit, json, jsonl or github")
+var JUnitOutPath = flag.String("junit-out", "", "also write the test results in junit format to this file")
+var HTMLReportPath = flag.String("html-report", "", "also write the test results as a self-contained HTML page to this file")
 var ExecutionStrategy = flag.String("s", "lambda", "execution strategy;\n  lambda - run most tests remote, some locally;\n  lambda_skip - run most tests remote, skip others;\n  lambda_emulator - run all tests against a local lambda simulator")
 var EnvCreds = flag.Bool("use-aws-environment-credentials", false, "by default we use hard-coded credentials which work for DoltHub developers; this uses credentials from the environment instead.")
 var TargetArch = flag.String("arch", "arm64", "target architecture for the lambda function; either amd64 or arm64")
 var BuildOnly = flag.Bool("build-only", false, "Print the location of the test artifacts and exit without running the tests.")
 var Race = flag.Bool("race", false, "Build dolt in race mode so that tests will fail if data races are detected.")
-var RunAllCount = flag.Int("count", 1, "Run all the tests multiple times. Can help track down flakiness.")
-var DuplicateTestsCount = flag.Int("duplicate", 1, "Duplicate the tests in each test file this many times. Can help track down flakiness.")
+var RunAllCount = flag.Int("count", 1, "Run all the tests multiple times, one pass after another. Can help track down flakiness.")
+var DuplicateTestsCount = flag.Int("duplicate", 1, "Run each test this many times concurrently in each pass. Can help track down flakiness.")
+var Timings = flag.Int("timings", 0, "after the results, print this many of the slowest tests, the total time of each file, and how much overhead running in Lambda added")
+var QuarantinePath = flag.String("quarantine", "", "a JSON file of known-flaky tests, whose failures are reported separately and do not fail the run; defaults to "+QuarantineFileName+" next to the bats directory")
+var FlakinessReportPath = flag.String("flakiness-report", "", "write a JSON report of how each test fared across all of its runs to this file")
+var NameFilter = flag.String("f", "", "only run tests whose names match this regular expression")
+var FilterStatus = flag.String("filter-status", "", "only run tests with this status in the last run; currently only failed is supported")
+var InfraRetries = flag.Int("infra-retries", 3, "retry a test up to this many times when running it fails because of Lambda or S3 errors, as opposed to the test failing")
+var RetryFailures = flag.Int("retry-failures", 0, "rerun each failed test up to this many more times, until it passes, to tell flaky tests from consistently failing ones")
+var Flaky = flag.String("flaky", "fail", "how to treat tests which both failed and passed when computing the exit code; either fail or pass")
+var Hedge = flag.Bool("hedge", false, "when a test takes much longer than expected, start a second invocation of it and take whichever finishes first; the losing invocation keeps running in Lambda until it finishes, so the concurrency limit is approximate")
+var HedgeFactor = flag.Float64("hedge-factor", 3, "with --hedge, hedge a test after it runs this many times longer than its last recorded duration, or than the 95th percentile of the tests run so far")
+var TestTimeout = flag.Duration("test-timeout", 0, "kill a test and report it as timed out if it runs longer than this; a test tagged timeout:SECONDS uses that instead. 0 means no limit")
+var Stream = flag.Bool("stream", false, "print each failure above the progress bar as soon as it happens, and keep a count of the passed, failed and skipped tests on the bar")
+var NoFailFocusRun = flag.Bool("no-fail-focus-run", os.Getenv("BATS_NO_FAIL_FOCUS_RUN") != "", "when tests tagged bats:focus are found, only warn instead of failing the run. Also enabled by setting BATS_NO_FAIL_FOCUS_RUN.")
 
 var EnvVars []string
+var FilterTags [][]string
+var FailFast FailFastFlag
 
 func PrintUsage() {
-	fmt.Println("usage: lambda-bats [-F pretty|tap] [-s lambda|lambda_skip|lambda_emulator] BATS_DIR_OR_FILES...")
+	fmt.Println("usage: lambda-bats [-F pretty|tap|tap13|junit|json|jsonl|github] [-s lambda|lambda_skip|lambda_emulator] [-f REGEX] [--filter-tags TAG_LIST] [--filter-status failed] BATS_DIR_OR_FILES...")
 	fmt.Println("usage: lambda-bats login [--headless] - SSO login to AWS as a developer. Must have AWS CLI installed.")
+	fmt.Println("usage: lambda-bats rerun-failed [FLAGS] [BATS_DIR_OR_FILES...] - rerun the tests which failed in the last run.")
+	fmt.Println("usage: lambda-bats history list|test|flakes ... - query the results of past runs.")
+	fmt.Println("usage: lambda-bats diff RUN_A RUN_B - compare two runs from the history or -F json results.")
 	os.Exit(1)
 }
 
@@ -96,6 +123,17 @@ func main() {
 		}
 		os.Exit(DoLogin(useDeviceCode))
 	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(DoHistory(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(DoDiff(os.Args[2:]))
+	}
+	// rerun-failed takes the same flags as a normal run.
+	rerunFailed := len(os.Args) > 1 && os.Args[1] == "rerun-failed"
+	if rerunFailed {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 
 	flag.Func("env", "environment variable to set in the remote invocation; for example -env SQL_ENGINE=remote-engine", func(val string) error {
 		if !strings.Contains(val, "=") {
@@ -104,14 +142,47 @@ func main() {
 		EnvVars = append(EnvVars, val)
 		return nil
 	})
+	flag.Func("filter-tags", "only run tests which have all of these comma-separated tags; negate a tag with a leading !; can be given multiple times to run tests matching any of the lists", func(val string) error {
+		FilterTags = append(FilterTags, ParseFilterTags(val))
+		return nil
+	})
+
+	flag.Var(&FailFast, "fail-fast", "stop starting tests once one has failed, or with --fail-fast=N, once N have; the tests which were not started are reported as not run")
 
 	flag.Parse()
 
-	if *OutputFormat != "pretty" && *OutputFormat != "tap" {
+	// Rerun the failures with the same configuration as the last run,
+	// unless it is overridden on the command line.
+	var lastRun HistoryRun
+	if rerunFailed {
+		var err error
+		lastRun, err = LoadLastHistoryRun()
+		if errors.Is(err, ErrNoRecordedRun) {
+			fmt.Println("There is no recorded run to rerun the failures of.")
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Printf("could not load the last run: %v\n", err)
+			os.Exit(1)
+		}
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		if !set["arch"] {
+			*TargetArch = lastRun.Arch
+		}
+		if !set["race"] {
+			*Race = lastRun.Race
+		}
+		if !set["env"] {
+			EnvVars = lastRun.EnvVars
+		}
+	}
+
+	OutputResults, ok := OutputFormats[*OutputFormat]
+	if !ok {
 		fmt.Println("invalid output format")
 		PrintUsage()
-	} else if *OutputFormat == "tap" {
-		OutputResults = OutputTAPResults
 	}
 	if *ExecutionStrategy != "lambda" && *ExecutionStrategy != "lambda_skip" && *ExecutionStrategy != "lambda_emulator" {
 		fmt.Println("invalid execution strategy")
@@ -121,13 +192,24 @@ func main() {
 		fmt.Println("invalid target architecture")
 		PrintUsage()
 	}
+	if *Flaky != "fail" && *Flaky != "pass" {
+		fmt.Println("invalid flaky treatment")
+		PrintUsage()
+	}
+	if *FilterStatus != "" && *FilterStatus != "failed" {
+		fmt.Println("invalid filter status")
+		PrintUsage()
+	}
 
 	if *TargetArch == "amd64" {
-		fmt.Println("Forcing --build-only because run on x86_64 is not supported")
+		fmt.Fprintln(os.Stderr, "Forcing --build-only because run on x86_64 is not supported")
 		*BuildOnly = true
 	}
 
 	fileArgs := flag.Args()
+	if rerunFailed && len(fileArgs) == 0 {
+		fileArgs = []string{lastRun.BatsDir}
+	}
 	if len(fileArgs) == 0 {
 		fmt.Println("must supply tests to run")
 		PrintUsage()
@@ -138,6 +220,49 @@ func main() {
 		fmt.Printf("could not find dolt source directory: %v\n", err)
 		PrintUsage()
 	}
+	batsDir, err := filepath.Abs(filepath.Join(doltSrcDir, "integration-tests/bats"))
+	if err != nil {
+		panic(err)
+	}
+	historyConfig := HistoryRunConfig{
+		BatsDir:    batsDir,
+		DoltCommit: DoltCommit(doltSrcDir),
+		Strategy:   *ExecutionStrategy,
+		Arch:       *TargetArch,
+		Race:       *Race,
+	}
+	startTime := time.Now()
+
+	filter := TestFilter{Tags: FilterTags}
+	if *NameFilter != "" {
+		filter.Name, err = regexp.Compile(*NameFilter)
+		if err != nil {
+			fmt.Printf("invalid filter regex: %v\n", err)
+			PrintUsage()
+		}
+	}
+	if *FilterStatus == "failed" {
+		filter.Only, err = LoadFailedTests(batsDir)
+		if err != nil {
+			fmt.Printf("could not use --filter-status failed: %v\n", err)
+			os.Exit(1)
+		}
+		if len(filter.Only) == 0 {
+			fmt.Println("There were no failed tests in the last recorded run.")
+			os.Exit(0)
+		}
+	}
+	if rerunFailed {
+		if lastRun.BatsDir != batsDir {
+			fmt.Fprintf(os.Stderr, "The last run was against the tests in %s, not %s. Give no files to rerun its failures there.\n", lastRun.BatsDir, batsDir)
+			os.Exit(1)
+		}
+		filter.Only = lastRun.FailedTests()
+		if len(filter.Only) == 0 {
+			fmt.Println("There were no failed tests in the last recorded run.")
+			os.Exit(0)
+		}
+	}
 
 	ctx := context.Background()
 
@@ -150,7 +275,7 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		fallbackRunner = NewLocalRunner(filepath.Join(doltSrcDir, "integration-tests/bats"))
+		fallbackRunner = NewLocalRunner(batsDir)
 	case "lambda_skip":
 		config, err = NewAWSRunConfig(ctx, *EnvCreds)
 		if err != nil {
@@ -161,72 +286,194 @@ func main() {
 		config = NewTestRunConfig()
 	}
 
-	testArtifacts, err := UploadTests(ctx, config.Uploader, doltSrcDir, *TargetArch, *BuildOnly, *Race)
-	if err != nil {
-		panic(err)
+	// The hashes of the sources are recorded in the history, so that a
+	// later rerun-failed can tell whether it can reuse the artifacts built
+	// from them. If hashing fails, none are recorded, and the artifacts
+	// are never reused.
+	hashSources := func() {
+		sources, err := HashSources(doltSrcDir, batsDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not hash the dolt sources; their artifacts will not be reused: %v\n", err)
+			return
+		}
+		historyConfig.Sources = sources
+	}
+	var testArtifacts UploadLocations
+	if rerunFailed && !*BuildOnly {
+		hashSources()
+	}
+	if rerunFailed && !*BuildOnly && lastRun.CanReuseArtifacts(historyConfig) {
+		fmt.Fprintf(os.Stderr, "Reusing the test artifacts from run %s, since the sources have not changed.\n", lastRun.ID)
+		testArtifacts = lastRun.UploadLocations()
+	} else {
+		// Nothing is recorded for --build-only. Otherwise, hash the
+		// sources while the artifacts are being built from them.
+		var hashed sync.WaitGroup
+		if !rerunFailed && !*BuildOnly {
+			hashed.Add(1)
+			go func() {
+				defer hashed.Done()
+				hashSources()
+			}()
+		}
+		testArtifacts, err = UploadTests(ctx, config.Uploader, doltSrcDir, *TargetArch, *BuildOnly, *Race)
+		hashed.Wait()
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	if *BuildOnly {
-		fmt.Println("Test artifacts saved. Exiting.")
+		fmt.Fprintln(os.Stderr, "Test artifacts saved. Exiting.")
 		os.Exit(0)
 	}
 
-	var res int
-	for i := 0; i < *RunAllCount; i++ {
-		files, total, err := LoadTestFiles(fileArgs, *DuplicateTestsCount)
+	dispatcher := &Dispatcher{
+		Artifacts:   testArtifacts,
+		Lambda:      NewRetryingRunner(config.Runner, *InfraRetries),
+		Local:       NewRetryingRunner(fallbackRunner, *InfraRetries),
+		Concurrency: config.Concurrency,
+		TestTimeout: *TestTimeout,
+		FailFast:    int(FailFast),
+	}
+	if *Hedge {
+		durations, err := LoadTestDurations()
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "could not load previous test durations: %v\n", err)
 		}
+		dispatcher.Hedger = NewHedger(*HedgeFactor, durations)
+	}
+
+	files, _, err := LoadTestFiles(fileArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	loadedFiles := files
+	files, total := FilterTestFiles(files, filter)
+	files, total, focus := FocusTestFiles(files, total)
+
+	quarantinePath := *QuarantinePath
+	if quarantinePath == "" {
+		quarantinePath = DefaultQuarantinePath(batsDir)
+	}
+	quarantine, err := LoadQuarantine(quarantinePath)
+	if err != nil {
+		fmt.Printf("could not load quarantine: %v\n", err)
+		os.Exit(1)
+	}
+	ApplyQuarantine(files, quarantine)
 
-		eg, egCtx := errgroup.WithContext(ctx)
-		eg.SetLimit(config.Concurrency)
-		bar := progressbar.Default(int64(total), "running tests")
-
-		RunTest := func(fi, ti int) {
-			eg.Go(func() error {
-				filter := EscapeNameForFilter(files[fi].Tests[ti].Name)
-				req := wire.RunTestRequest{
-					DoltLocation: testArtifacts.DoltPath,
-					BinLocation:  testArtifacts.BinPath,
-					BatsLocation: testArtifacts.TestsPath,
-					FileName:     files[fi].Name,
-					TestName:     files[fi].Tests[ti].Name,
-					TestFilter:   filter,
-					EnvVars:      EnvVars,
-				}
-				runner := config.Runner
-				if files[fi].Tests[ti].HasTag("no_lambda") {
-					runner = fallbackRunner
-				}
-				resp, err := runner.Run(egCtx, req)
-				if err != nil {
-					return err
-				}
-				bar.Add(1)
-				files[fi].Tests[ti].Runs = append(files[fi].Tests[ti].Runs, TestRun{
-					Response: resp,
-				})
-				return nil
-			})
+	// Every test is run -duplicate times concurrently, and that is
+	// repeated -count times. All the runs are collected into the test's
+	// Runs.
+	runsPerPass := *DuplicateTestsCount
+	runCtx, restoreSignals := NotifyInterrupt(ctx)
+	bar := NewTestProgressBar(total**RunAllCount*runsPerPass, "running tests", *Stream)
+	if *Stream {
+		dispatcher.Stream = NewResultStream(bar)
+	}
+	for range *RunAllCount {
+		if runCtx.Err() != nil || dispatcher.FailedFast() {
+			break
+		}
+		var tests []*Test
+		for range runsPerPass {
+			tests = append(tests, AllTests(files)...)
 		}
+		dispatcher.RunTests(runCtx, tests, bar)
+	}
+	stopped := runCtx.Err() != nil || dispatcher.FailedFast()
+	if stopped {
+		bar.Exit()
+	} else {
+		bar.Finish()
+	}
+	bar.Close()
 
-		// Run all the tests...
-		for fi := range files {
-			for ti := range files[fi].Tests {
-				RunTest(fi, ti)
+	// Rerun the failures, to tell flaky tests from broken ones...
+	if *RetryFailures > 0 && !stopped {
+		var failed []*Test
+		for _, t := range AllTests(files) {
+			if s := t.Summary().Status; s == TestStatus_Failed || s == TestStatus_TimedOut {
+				failed = append(failed, t)
 			}
 		}
-		err = eg.Wait()
-		if err != nil {
-			panic(err)
+		if len(failed) > 0 {
+			bar := NewTestProgressBar(len(failed)**RetryFailures, "retrying failed tests", *Stream)
+			if *Stream {
+				dispatcher.Stream = NewResultStream(bar)
+			}
+			dispatcher.RetryFailures(runCtx, failed, *RetryFailures, bar)
+			if runCtx.Err() != nil {
+				bar.Exit()
+			} else {
+				bar.Finish()
+			}
+			bar.Close()
 		}
-		bar.Finish()
-		bar.Close()
+	}
+	interrupted := runCtx.Err() != nil
+	restoreSignals()
 
-		// Print the results...
-		res = OutputResults(files)
-		if res != 0 {
-			os.Exit(res)
+	// Print the results...
+	info := RunInfo{
+		Focus:        focus,
+		FailFocusRun: !*NoFailFocusRun,
+		FlakesPass:   *Flaky == "pass",
+		Artifacts:    testArtifacts,
+		Interrupted:  interrupted,
+	}
+	if dispatcher.FailedFast() {
+		info.FailedFast = dispatcher.FailFast
+	}
+	if len(quarantine) > 0 {
+		history, err := LoadHistoryRuns(quarantineHistoryRuns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not load history to check for stale quarantine entries: %v\n", err)
+		}
+		info.StaleQuarantine = StaleQuarantineEntries(files, history)
+		info.UnmatchedQuarantine = UnmatchedQuarantineEntries(batsDir, loadedFiles, quarantine)
+	}
+	res := OutputResults(files, info)
+	if *JUnitOutPath != "" {
+		err = WriteJUnitResultsFile(*JUnitOutPath, files, info)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not write junit results: %v\n", err)
+		}
+	}
+	if *Timings > 0 {
+		// Keep machine-readable results on stdout parseable.
+		w := os.Stdout
+		if *OutputFormat != "pretty" && *OutputFormat != "github" {
+			w = os.Stderr
+		}
+		fmt.Fprintln(w)
+		err = WriteTimingsReport(w, files, *Timings)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not write timings: %v\n", err)
+		}
+	}
+	if *HTMLReportPath != "" {
+		err = WriteHTMLReportFile(*HTMLReportPath, files, info)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not write html report: %v\n", err)
+		}
+	}
+	if *FlakinessReportPath != "" {
+		err = WriteFlakinessReport(*FlakinessReportPath, files)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not write flakiness report: %v\n", err)
+		}
+	}
+	err = SaveHistoryRun(NewHistoryRun(startTime, historyConfig, files, info))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not save run to history: %v\n", err)
+	}
+	if dispatcher.Hedger != nil {
+		err = dispatcher.Hedger.SaveTestDurations()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not record test durations: %v\n", err)
 		}
 	}
 	os.Exit(res)
@@ -56,6 +56,9 @@ func (r *ProgressBarReader) Close() error {
 	return r.f.Close()
 }
 
+// Print |message| with a spinner after it while |work| runs. Like the
+// progress bars, the spinner goes to stderr, so that it is not mixed into
+// the results on stdout.
 func RunWithSpinner(message string, work func() error) error {
 	done := make(chan struct{})
 	var eg errgroup.Group
@@ -66,18 +69,18 @@ func RunWithSpinner(message string, work func() error) error {
 	eg.Go(func() error {
 		i := 0
 		spinner := []byte{'|', '/', '-', '\\'}
-		fmt.Printf("%s %c", message, spinner[i])
+		fmt.Fprintf(os.Stderr, "%s %c", message, spinner[i])
 		for {
 			select {
 			case <-done:
-				fmt.Printf("\bdone\n")
+				fmt.Fprintf(os.Stderr, "\bdone\n")
 				return nil
 			case <-time.After(100 * time.Millisecond):
 				i += 1
 				if i == len(spinner) {
 					i = 0
 				}
-				fmt.Printf("\b%c", spinner[i])
+				fmt.Fprintf(os.Stderr, "\b%c", spinner[i])
 			}
 		}
 		return nil
@@ -85,15 +88,274 @@ func RunWithSpinner(message string, work func() error) error {
 	return eg.Wait()
 }
 
-type OutputResultsFunc = func(files []TestFile) int
+// Information about the run as a whole, as opposed to its individual tests.
+type RunInfo struct {
+	// Only tests tagged bats:focus were run.
+	Focus bool
+
+	// Mark the run as failed if Focus is set, so that focused tests do not
+	// get committed.
+	FailFocusRun bool
+
+	// Treat tests which failed on some runs and passed on others as
+	// passing when computing the exit code.
+	FlakesPass bool
+
+	// Where the artifacts the tests ran against were uploaded.
+	Artifacts UploadLocations
+
+	// Quarantined tests which have been passing consistently, and could
+	// be taken out of the quarantine.
+	StaleQuarantine []QuarantineEntry
+
+	// Quarantine entries which do not match any test.
+	UnmatchedQuarantine []QuarantineEntry
+
+	// The run was interrupted, so some of the tests may not have run.
+	Interrupted bool
+
+	// If non-zero, the run was stopped by --fail-fast after this many
+	// tests failed, so some of the tests may not have run.
+	FailedFast int
+}
+
+// Explains why some tests were not run when the run was stopped early. Empty
+// if it was not.
+func (info RunInfo) StoppedWarning() string {
+	if info.Interrupted {
+		return "The run was interrupted; the tests which had not started yet were not run."
+	}
+	if info.FailedFast > 0 {
+		return fmt.Sprintf("The run was stopped by --fail-fast after %d failed tests; the tests which had not started yet were not run.", info.FailedFast)
+	}
+	return ""
+}
+
+type OutputResultsFunc = func(files []TestFile, info RunInfo) int
+
+// Returns true if |t|, with summary |s|, failing should fail the run.
+// Flaky tests do not with FlakesPass, and quarantined tests never do.
+func (info RunInfo) FailsRun(t Test, s TestSummary) bool {
+	if !s.Failing() || t.Quarantine != nil {
+		return false
+	}
+	return s.Status != TestStatus_Flaky || !info.FlakesPass
+}
+
+// The exit code for a run with these results; 0 if everything passed.
+func ExitCode(files []TestFile, info RunInfo) int {
+	if info.Focus && info.FailFocusRun {
+		return 1
+	}
+	if info.Interrupted || info.FailedFast > 0 {
+		return 1
+	}
+	for _, f := range files {
+		for _, t := range f.Tests {
+			if info.FailsRun(t, t.Summary()) {
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// Describes who owns a quarantined test and why it is quarantined.
+func quarantineNote(e *QuarantineEntry) string {
+	note := "quarantined"
+	if e.Owner != "" {
+		note += " by " + e.Owner
+	}
+	if e.Reason != "" {
+		note += ": " + e.Reason
+	}
+	return note
+}
+
+// Print the failures of quarantined tests, which are left out of the
+// results for their files, and the quarantine entries which look stale or
+// do not match any test.
+func printQuarantine(files []TestFile, info RunInfo) int {
+	yellow := color.New(color.FgYellow)
+	blue := color.New(color.FgBlue)
+	numFailed := 0
+	for _, f := range files {
+		for _, t := range f.Tests {
+			s := t.Summary()
+			if t.Quarantine == nil || !s.Failing() {
+				continue
+			}
+			if numFailed == 0 {
+				yellow.Println("quarantined failures (not counted)")
+			}
+			numFailed += 1
+			yellow.Printf("  %s: %s (%s)\n", f.Name, t.Name, quarantineNote(t.Quarantine))
+			for _, line := range failureOutput(t, s) {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+	}
+	if numFailed > 0 {
+		fmt.Println()
+	}
+	if len(info.StaleQuarantine) > 0 {
+		blue.Printf("these quarantined tests passed in their last %d runs and can probably be removed from the quarantine\n", quarantineStaleRuns)
+		for _, e := range info.StaleQuarantine {
+			fmt.Printf("  %s: %s\n", e.File, e.Test)
+		}
+		fmt.Println()
+	}
+	if len(info.UnmatchedQuarantine) > 0 {
+		blue.Println("these quarantine entries do not match any test and can probably be removed from the quarantine")
+		for _, e := range info.UnmatchedQuarantine {
+			fmt.Printf("  %s: %s\n", e.File, e.Test)
+		}
+		fmt.Println()
+	}
+	return numFailed
+}
+
+// Describes the infrastructure retries it took to run a test, such as "
+// (passed after 2 infra retries)". Empty if there were none.
+func infraRetriesNote(n int, passed bool) string {
+	if n == 0 {
+		return ""
+	}
+	retries := "retries"
+	if n == 1 {
+		retries = "retry"
+	}
+	if passed {
+		return fmt.Sprintf(" (passed after %d infra %s)", n, retries)
+	}
+	return fmt.Sprintf(" (after %d infra %s)", n, retries)
+}
+
+// Describes the outcome of a test which was run more than once, such as "flaky
+// (passed 2/3)". Empty if the test was only run once.
+func runsNote(s TestSummary) string {
+	if s.Runs() < 2 {
+		return ""
+	}
+	if s.Status == TestStatus_Flaky {
+		return fmt.Sprintf("flaky (passed %d/%d)", s.Passed, s.Runs())
+	}
+	if s.Status == TestStatus_Failed || s.Status == TestStatus_Fatal || s.Status == TestStatus_TimedOut {
+		return fmt.Sprintf("failed consistently (%d/%d)", s.Failed+s.Fatal+s.TimedOut, s.Runs())
+	}
+	return ""
+}
+
+// The lines of output explaining why a test failed. If it was run more than
+// once, each distinct failure output is included once.
+func failureOutput(t Test, s TestSummary) []string {
+	if s.Failure == nil {
+		return nil
+	}
+	if s.Runs() > 1 {
+		var lines []string
+		for _, f := range t.DistinctFailures() {
+			lines = append(lines, fmt.Sprintf("%d/%d runs failed with:", f.Count, s.Runs()))
+			lines = append(lines, strings.Split(f.Output, "\n")...)
+		}
+		return lines
+	}
+	if s.FailureErr != nil {
+		lines := strings.Split(s.Failure.Response.Err, "\n")
+		return append(lines, strings.Split(s.Failure.Response.Output, "\n")...)
+	}
+	return strings.Split(s.FailureResult.Output, "\n")
+}
+
+// Describes how many test runs were hedged and how the hedges turned out.
+// When the hedge usually wins, the slowness was coming from Lambda, for
+// example cold starts. When the original usually wins, the tests themselves
+// are slow. Empty if nothing was hedged.
+func hedgeSummary(files []TestFile) string {
+	hedged := 0
+	won := 0
+	for _, f := range files {
+		for _, t := range f.Tests {
+			for _, run := range t.Runs {
+				if run.Hedged {
+					hedged += 1
+				}
+				if run.HedgeWon {
+					won += 1
+				}
+			}
+		}
+	}
+	if hedged == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d test runs were hedged; the hedge finished first %d times and the original %d times", hedged, won, hedged-won)
+}
+
+// Runs of |t| which ended in an error running the test, as opposed to the
+// test failing.
+func infraErrors(t Test) []string {
+	var res []string
+	for _, run := range t.Runs {
+		if run.InfraErr != "" {
+			res = append(res, run.InfraErr)
+		}
+	}
+	return res
+}
+
+// Print the errors running tests, as opposed to tests failing, separately
+// from the test results, since they say nothing about the code under test.
+// Returns the number of test runs which ended in one.
+func printInfraErrors(files []TestFile) int {
+	n := 0
+	for _, f := range files {
+		for _, t := range f.Tests {
+			for _, err := range infraErrors(t) {
+				if n == 0 {
+					color.New(color.FgBlue).Println("infrastructure errors")
+				}
+				n += 1
+				fmt.Printf("  %s: %s: %s\n", f.Name, t.Name, err)
+			}
+		}
+	}
+	if n > 0 {
+		fmt.Println()
+	}
+	return n
+}
+
+// When tests were run more than once, print how often each test which failed
+// at least once failed.
+func printFlakiness(files []TestFile) {
+	header := false
+	for _, f := range files {
+		for _, t := range f.Tests {
+			s := t.Summary()
+			if s.Runs() < 2 || s.Failed+s.Fatal+s.TimedOut == 0 {
+				continue
+			}
+			if !header {
+				color.New(color.FgBlue).Println("flakiness")
+				header = true
+			}
+			fmt.Printf("  %s: %s: %d runs, %d passed, %d failed, %d fatal, %d timed out, %d skipped, %.0f%% failure rate, %d distinct failures\n",
+				f.Name, t.Name, s.Runs(), s.Passed, s.Failed, s.Fatal, s.TimedOut, s.Skipped, 100*s.FailureRate(), len(t.DistinctFailures()))
+		}
+	}
+	if header {
+		fmt.Println()
+	}
+}
+
+const focusWarning = "WARNING: This test run only contains tests tagged `bats:focus`!"
+const focusFailure = "Marking test run as failed due to `bats:focus` tag. (Use --no-fail-focus-run or set BATS_NO_FAIL_FOCUS_RUN=1 to disable.)"
 
 func allSuccess(test TestFile) bool {
 	for _, t := range test.Tests {
-		res, err := t.Runs[0].Result(t.Name)
-		if err != nil {
-			return false
-		}
-		if res.Status != TestRunResultStatus_Success && res.Status != TestRunResultStatus_Skipped {
+		s := t.Summary()
+		if s.Status != TestStatus_Passed && s.Status != TestStatus_Skipped {
 			return false
 		}
 	}
@@ -101,10 +363,11 @@ func allSuccess(test TestFile) bool {
 	return true
 }
 
-func OutputBatsResults(files []TestFile) int {
+func OutputBatsResults(files []TestFile, info RunInfo) int {
 	blue := color.New(color.FgBlue)
 	red := color.New(color.FgRed)
 	green := color.New(color.FgGreen)
+	yellow := color.New(color.FgYellow)
 
 	// Note this is log(n^2) thanks to running allSuccess repeatedly.
 	//TODO - precompute the stats of each file, and pretty print all the uninteresting things first.
@@ -125,7 +388,16 @@ func OutputBatsResults(files []TestFile) int {
 	numSkipped := 0
 	numFailed := 0
 	numFatal := 0
+	numTimedOut := 0
+	numNotRun := 0
+	numFlaky := 0
+	numRetried := 0
 	for _, f := range files {
+		for _, t := range f.Tests {
+			if t.Summary().InfraRetries > 0 {
+				numRetried += 1
+			}
+		}
 		if allSuccess(f) {
 			green.Printf("%s 100%% PASSED\n", f.Name)
 			numTests += len(f.Tests)
@@ -133,31 +405,49 @@ func OutputBatsResults(files []TestFile) int {
 			blue.Println(f.Name)
 			for _, t := range f.Tests {
 				numTests += 1
-				res, err := t.Runs[0].Result(t.Name)
-				if err != nil {
-					numFatal += 1
-					red.Printf("  ✗ %s\n", t.Name)
-					for _, line := range strings.Split(t.Runs[0].Response.Err, "\n") {
-						red.Printf("  %s\n", line)
-					}
-					for _, line := range strings.Split(t.Runs[0].Response.Output, "\n") {
-						red.Printf("  %s\n", line)
-					}
+				s := t.Summary()
+				if t.Quarantine != nil && s.Failing() {
+					// The failure is printed with the other quarantined
+					// ones, after all the files.
+					yellow.Printf("  ! %s (%s)\n", t.Name, quarantineNote(t.Quarantine))
 					continue
 				}
-				if res.Status == TestRunResultStatus_Success {
-					fmt.Printf("  ✓ %s\n", t.Name)
-				} else if res.Status == TestRunResultStatus_Skipped {
+				switch s.Status {
+				case TestStatus_Passed:
+					fmt.Printf("  ✓ %s%s\n", t.Name, infraRetriesNote(s.InfraRetries, true))
+				case TestStatus_Skipped:
 					numSkipped += 1
-					if res.Output == "" {
+					if s.SkipReason == "" {
 						fmt.Printf("  - %s (skipped)\n", t.Name)
 					} else {
-						fmt.Printf("  - %s (skipped: %s)\n", t.Name, res.Output)
+						fmt.Printf("  - %s (skipped: %s)\n", t.Name, s.SkipReason)
 					}
-				} else {
-					numFailed += 1
-					red.Printf("  ✗ %s\n", t.Name)
-					for _, line := range strings.Split(res.Output, "\n") {
+				case TestStatus_NotRun:
+					numNotRun += 1
+					fmt.Printf("  - %s (not run)\n", t.Name)
+				case TestStatus_Flaky:
+					numFlaky += 1
+					yellow.Printf("  ~ %s (%s)%s\n", t.Name, runsNote(s), infraRetriesNote(s.InfraRetries, false))
+					for _, line := range failureOutput(t, s) {
+						yellow.Printf("  %s\n", line)
+					}
+				default:
+					if s.Status == TestStatus_Fatal {
+						numFatal += 1
+					} else {
+						numFailed += 1
+						if s.Status == TestStatus_TimedOut {
+							numTimedOut += 1
+						}
+					}
+					if s.Status == TestStatus_TimedOut && s.Runs() < 2 {
+						red.Printf("  ✗ %s (timed out)%s\n", t.Name, infraRetriesNote(s.InfraRetries, false))
+					} else if note := runsNote(s); note != "" {
+						red.Printf("  ✗ %s (%s)%s\n", t.Name, note, infraRetriesNote(s.InfraRetries, false))
+					} else {
+						red.Printf("  ✗ %s%s\n", t.Name, infraRetriesNote(s.InfraRetries, false))
+					}
+					for _, line := range failureOutput(t, s) {
 						red.Printf("  %s\n", line)
 					}
 				}
@@ -165,24 +455,54 @@ func OutputBatsResults(files []TestFile) int {
 			fmt.Println()
 		}
 	}
+	printFlakiness(files)
+	numInfraErrors := printInfraErrors(files)
+	numQuarantined := printQuarantine(files, info)
+	if info.Focus {
+		red.Println(focusWarning)
+	}
+	if numRetried > 0 {
+		blue.Printf("%d tests were retried because of infrastructure errors\n", numRetried)
+	}
+	if numInfraErrors > 0 {
+		red.Printf("%d test runs could not be completed because of infrastructure errors\n", numInfraErrors)
+	}
+	if hedges := hedgeSummary(files); hedges != "" {
+		blue.Println(hedges)
+	}
+	flaky := ""
+	if numFlaky > 0 {
+		flaky = fmt.Sprintf(", %d flaky", numFlaky)
+	}
+	if numQuarantined > 0 {
+		flaky += fmt.Sprintf(", %d quarantined failures", numQuarantined)
+	}
+	if numTimedOut > 0 {
+		flaky += fmt.Sprintf(", %d timed out", numTimedOut)
+	}
+	if numNotRun > 0 {
+		flaky += fmt.Sprintf(", %d not run", numNotRun)
+	}
+	if warning := info.StoppedWarning(); warning != "" {
+		red.Println(warning)
+	}
 	if numFatal > 0 {
-		red.Printf("%d tests, %d fatal, %d failures, %d skipped\n", numTests, numFatal, numFailed, numSkipped)
+		red.Printf("%d tests, %d fatal, %d failures%s, %d skipped\n", numTests, numFatal, numFailed, flaky, numSkipped)
 	} else if numFailed > 0 {
-		red.Printf("%d tests, %d failures, %d skipped\n", numTests, numFailed, numSkipped)
+		red.Printf("%d tests, %d failures%s, %d skipped\n", numTests, numFailed, flaky, numSkipped)
+	} else if numFlaky > 0 {
+		yellow.Printf("%d tests, %d failures%s, %d skipped\n", numTests, numFailed, flaky, numSkipped)
 	} else {
 		fmt.Printf("%d tests, %d failures, %d skipped\n", numTests, numFailed, numSkipped)
 	}
-
-	if numFailed == 0 && numFatal == 0 {
-		return 0
+	if info.Focus && info.FailFocusRun {
+		red.Println(focusFailure)
 	}
-	return 1
+	return ExitCode(files, info)
 }
 
-func OutputTAPResults(files []TestFile) int {
+func OutputTAPResults(files []TestFile, info RunInfo) int {
 	numTests := 0
-	numFailed := 0
-	numFatal := 0
 	for _, f := range files {
 		numTests += len(f.Tests)
 	}
@@ -190,39 +510,58 @@ func OutputTAPResults(files []TestFile) int {
 	i := 1
 	for _, f := range files {
 		for _, t := range f.Tests {
-			res, err := t.Runs[0].Result(t.Name)
-			if err != nil {
-				numFatal += 1
-				fmt.Printf("not ok %d %s\n", i, t.Name)
-				for _, line := range strings.Split(t.Runs[0].Response.Err, "\n") {
-					fmt.Printf("#%s\n", line)
-				}
-				for _, line := range strings.Split(t.Runs[0].Response.Output, "\n") {
-					fmt.Printf("#%s\n", line)
-				}
-				continue
-			}
-			if res.Status == TestRunResultStatus_Success {
+			s := t.Summary()
+			switch s.Status {
+			case TestStatus_Passed:
 				fmt.Printf("ok %d %s\n", i, t.Name)
-			} else if res.Status == TestRunResultStatus_Skipped {
-				if res.Output == "" {
+				if note := infraRetriesNote(s.InfraRetries, true); note != "" {
+					fmt.Printf("#%s\n", note)
+				}
+			case TestStatus_Skipped:
+				if s.SkipReason == "" {
 					fmt.Printf("ok %d %s # skip\n", i, t.Name)
 				} else {
-					fmt.Printf("ok %d %s # skip %s\n", i, t.Name, res.Output)
+					fmt.Printf("ok %d %s # skip %s\n", i, t.Name, s.SkipReason)
 				}
-			} else {
-				numFailed += 1
-				fmt.Printf("not ok %d %s\n", i, t.Name)
-				for _, line := range strings.Split(res.Output, "\n") {
+			case TestStatus_NotRun:
+				fmt.Printf("ok %d %s # skip not run\n", i, t.Name)
+			default:
+				if t.Quarantine != nil {
+					// TODO tests are expected to fail, and do not
+					// count as failures.
+					fmt.Printf("not ok %d %s # TODO %s\n", i, t.Name, quarantineNote(t.Quarantine))
+				} else if !info.FailsRun(t, s) {
+					fmt.Printf("ok %d %s\n", i, t.Name)
+				} else {
+					fmt.Printf("not ok %d %s\n", i, t.Name)
+				}
+				if note := runsNote(s); note != "" {
+					fmt.Printf("# %s\n", note)
+				}
+				if note := infraRetriesNote(s.InfraRetries, false); note != "" {
+					fmt.Printf("#%s\n", note)
+				}
+				for _, line := range failureOutput(t, s) {
 					fmt.Printf("#%s\n", line)
 				}
 			}
 			i += 1
 		}
 	}
-
-	if numFailed == 0 && numFatal == 0 {
-		return 0
+	if hedges := hedgeSummary(files); hedges != "" {
+		fmt.Printf("# %s\n", hedges)
+	}
+	for _, e := range info.StaleQuarantine {
+		fmt.Printf("# stale quarantine entry, passed in its last %d runs: %s: %s\n", quarantineStaleRuns, e.File, e.Test)
+	}
+	for _, e := range info.UnmatchedQuarantine {
+		fmt.Printf("# quarantine entry does not match any test: %s: %s\n", e.File, e.Test)
+	}
+	if info.Focus {
+		fmt.Printf("# %s\n", focusWarning)
+		if info.FailFocusRun {
+			fmt.Printf("# %s\n", focusFailure)
+		}
 	}
-	return 1
+	return ExitCode(files, info)
 }
@@ -0,0 +1,435 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// A test declared with @test in a bats file.
+type batsTestDecl struct {
+	Name string
+	// The line the @test is on, starting from 1.
+	Line int
+	// From the `# bats test_tags=` comment before the test.
+	Tags []string
+	// From the last `# bats file_tags=` comment before the test.
+	FileTags []string
+}
+
+// All of the tags which apply to the test: its file tags followed by its
+// own test tags, without duplicates.
+func (d batsTestDecl) AllTags() []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, tags := range [][]string{d.FileTags, d.Tags} {
+		for _, tag := range tags {
+			if !seen[tag] {
+				seen[tag] = true
+				res = append(res, tag)
+			}
+		}
+	}
+	return res
+}
+
+// A syntax error in a bats file.
+type BatsSyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *BatsSyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+var batsTagsRegexp = regexp.MustCompile(`^#[ \t]*bats[ \t]+(test_tags|file_tags)=(.*)$`)
+
+// Like bats, tags may only contain letters, digits, _, - and :, where :
+// separates namespaces, as in bats:focus.
+var batsTagRegexp = regexp.MustCompile(`^[-_:a-zA-Z0-9]+$`)
+
+// Split a comma-separated list of bats tags, dropping the whitespace around
+// each tag and any empty ones.
+func parseBatsTags(list string) ([]string, error) {
+	var res []string
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if !batsTagRegexp.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q; tags may only contain letters, digits, _, - and :", tag)
+		}
+		res = append(res, tag)
+	}
+	return res, nil
+}
+
+type heredoc struct {
+	delim string
+	// For <<-, leading tabs are stripped from the terminating line.
+	stripTabs bool
+	// The line the heredoc was started on.
+	line int
+}
+
+// Parse the tests declared in a bats file.
+//
+// This understands enough of the bats and shell syntax to find the @test
+// declarations the way bats does: the test name can be double-quoted,
+// single-quoted or unquoted, @test can be indented, the opening brace of the
+// body can be on the next line, and lines inside heredocs, quoted strings
+// and command substitutions which span lines are never taken for
+// declarations or tag comments. A heredoc which is still open at the end of
+// the file is an error, since everything after its start would otherwise be
+// dropped.
+func parseBatsFile(r io.Reader) ([]batsTestDecl, error) {
+	var lines []string
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		lines = append(lines, strings.TrimSuffix(s.Text(), "\r"))
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	res, open, err := parseBatsLines(lines, 0)
+	if err == nil && open != 0 {
+		// The quoting rules here only approximate bash's, so a string
+		// which seems to never be closed is more likely to be misread
+		// than broken. Rather than fail, parse the file again without
+		// looking for strings or heredocs from the line it starts on.
+		res, _, err = parseBatsLines(lines, open)
+	}
+	return res, err
+}
+
+// Parse the tests declared in |lines|. If |stopScan| is not 0, quoted
+// strings and heredocs are not looked for from that line on. Returns the
+// line of a quoted string or command substitution which is still open at
+// the end, or 0.
+func parseBatsLines(lines []string, stopScan int) ([]batsTestDecl, int, error) {
+	var res []batsTestDecl
+	var tags, fileTags []string
+	var pending []heredoc
+	// The quoting contexts which are still open at the end of the last
+	// line, and the line the outermost one was opened on.
+	var quotes []byte
+	quoteLine := 0
+
+	lineNum := 0
+	next := func() (string, bool) {
+		if lineNum == len(lines) {
+			return "", false
+		}
+		lineNum += 1
+		return lines[lineNum-1], true
+	}
+	scan := func(line string) {
+		if stopScan != 0 && lineNum >= stopScan {
+			return
+		}
+		wasOpen := len(quotes) > 0
+		var started []heredoc
+		started, quotes = scanHeredocs(line, quotes)
+		for _, h := range started {
+			h.line = lineNum
+			pending = append(pending, h)
+		}
+		if !wasOpen && len(quotes) > 0 {
+			quoteLine = lineNum
+		}
+	}
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		// Heredoc bodies start after the line their command ends on,
+		// which is after any quoted string on it has been closed, but
+		// can be inside a command substitution.
+		if len(pending) > 0 && !inQuotedString(quotes) {
+			h := pending[0]
+			term := line
+			if h.stripTabs {
+				term = strings.TrimLeft(term, "\t")
+			}
+			if term == h.delim {
+				pending = pending[1:]
+			}
+			continue
+		}
+		if len(quotes) > 0 {
+			scan(line)
+			continue
+		}
+
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(trimmed, "#") {
+			if m := batsTagsRegexp.FindStringSubmatch(strings.TrimRight(trimmed, " \t")); m != nil {
+				parsed, err := parseBatsTags(m[2])
+				if err != nil {
+					return nil, 0, &BatsSyntaxError{Line: lineNum, Msg: err.Error()}
+				}
+				if m[1] == "test_tags" {
+					tags = parsed
+				} else {
+					fileTags = parsed
+				}
+			}
+			continue
+		}
+
+		rest, isTest := strings.CutPrefix(trimmed, "@test")
+		if !isTest || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+			scan(line)
+			continue
+		}
+		decl := batsTestDecl{Line: lineNum, Tags: tags, FileTags: fileTags}
+		words, body, brace, err := lexTestName(rest)
+		if err != nil {
+			return nil, 0, &BatsSyntaxError{Line: lineNum, Msg: err.Error()}
+		}
+		if len(words) == 0 {
+			return nil, 0, &BatsSyntaxError{Line: lineNum, Msg: "@test without a name"}
+		}
+		decl.Name = strings.Join(words, " ")
+		for !brace {
+			line, ok = next()
+			if !ok {
+				return nil, 0, &BatsSyntaxError{Line: decl.Line, Msg: fmt.Sprintf("expected { after @test %q", decl.Name)}
+			}
+			trimmed = strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			body, brace = strings.CutPrefix(trimmed, "{")
+			if !brace {
+				return nil, 0, &BatsSyntaxError{Line: lineNum, Msg: fmt.Sprintf("expected { after @test %q", decl.Name)}
+			}
+		}
+		res = append(res, decl)
+		tags = nil
+		scan(body)
+	}
+	if len(quotes) > 0 {
+		return res, quoteLine, nil
+	}
+	if len(pending) > 0 {
+		return nil, 0, &BatsSyntaxError{Line: pending[0].line, Msg: fmt.Sprintf("unterminated heredoc, expected a line with %s", pending[0].delim)}
+	}
+	return res, 0, nil
+}
+
+// Split the part of an @test line after @test into the words of the test
+// name, following the shell quoting rules, up to the opening brace of the
+// body. Returns what comes after the brace, and whether there was one.
+func lexTestName(s string) ([]string, string, bool, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			endWord()
+		case c == '{' && !inWord:
+			return words, s[i+1:], true, nil
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end == -1 {
+				return nil, "", false, errors.New("unterminated ' in test name")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '"':
+			inWord = true
+			i += 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				// Inside double quotes, a backslash only escapes the
+				// characters which are special there.
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) != -1 {
+					i += 1
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, "", false, errors.New("unterminated \" in test name")
+			}
+		case c == '\\' && i+1 < len(s):
+			i += 1
+			word.WriteByte(s[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endWord()
+	return words, "", false, nil
+}
+
+// Whether the innermost of the open quoting contexts |quotes|, as returned
+// by scanHeredocs, is a quoted string rather than a command substitution.
+func inQuotedString(quotes []byte) bool {
+	if len(quotes) == 0 {
+		return false
+	}
+	top := quotes[len(quotes)-1]
+	return top != '(' && top != '`'
+}
+
+// The heredocs started on |line|, in order, so that their bodies can be
+// skipped. Quoted strings, comments and arithmetic like $((x << 2)) are
+// not mistaken for heredocs, and neither are here-strings.
+//
+// |quotes| are the quoting contexts left open by the previous lines,
+// innermost last: ' and " for quoted strings, $ for $'...' strings, ( for
+// $(...) command substitutions and ` for backtick ones. Returns the ones
+// which are still open at the end of |line| in the same way. Command
+// substitutions nest inside double quotes and the other way around, as in
+// "$(echo "it's")".
+func scanHeredocs(line string, quotes []byte) ([]heredoc, []byte) {
+	var res []heredoc
+	push := func(q byte) {
+		quotes = append(quotes, q)
+	}
+	pop := func() {
+		quotes = quotes[:len(quotes)-1]
+	}
+	arith := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		top := byte(0)
+		if len(quotes) > 0 {
+			top = quotes[len(quotes)-1]
+		}
+		switch top {
+		case '\'':
+			if c == '\'' {
+				pop()
+			}
+			continue
+		case '$':
+			// Backslash escapes work inside $'...', as in $'it\'s'.
+			if c == '\\' {
+				i += 1
+			} else if c == '\'' {
+				pop()
+			}
+			continue
+		case '"':
+			switch {
+			case c == '\\':
+				i += 1
+			case c == '"':
+				pop()
+			case c == '`':
+				push('`')
+			case strings.HasPrefix(line[i:], "$(("):
+				i += 2
+			case strings.HasPrefix(line[i:], "$("):
+				push('(')
+				i += 1
+			}
+			continue
+		}
+		switch {
+		case c == '\\':
+			i += 1
+		case strings.HasPrefix(line[i:], "$'"):
+			push('$')
+			i += 1
+		case c == '\'' || c == '"':
+			push(c)
+		case c == '`' && top == '`':
+			pop()
+		case c == '`':
+			push('`')
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t' || line[i-1] == ';'):
+			return res, quotes
+		case strings.HasPrefix(line[i:], "$(("):
+			arith += 1
+			i += 2
+		case strings.HasPrefix(line[i:], "(("):
+			arith += 1
+			i += 1
+		case strings.HasPrefix(line[i:], "))") && arith > 0:
+			arith -= 1
+			i += 1
+		case strings.HasPrefix(line[i:], "$("):
+			push('(')
+			i += 1
+		case c == '(' && top != 0:
+			// A subshell inside a command substitution, so that its
+			// closing paren does not end the substitution.
+			push('(')
+		case c == ')' && top == '(':
+			pop()
+		case strings.HasPrefix(line[i:], "<<<"):
+			i += 2
+		case strings.HasPrefix(line[i:], "<<") && arith == 0:
+			i += 2
+			h := heredoc{}
+			if i < len(line) && line[i] == '-' {
+				h.stripTabs = true
+				i += 1
+			}
+			for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+				i += 1
+			}
+			var delim strings.Builder
+			for ; i < len(line) && strings.IndexByte(" \t;|&<>()", line[i]) == -1; i++ {
+				switch line[i] {
+				case '\'', '"':
+					q := line[i]
+					end := strings.IndexByte(line[i+1:], q)
+					if end == -1 {
+						end = len(line) - i - 1
+					}
+					delim.WriteString(line[i+1 : i+1+end])
+					i += end + 1
+				case '\\':
+					if i+1 < len(line) {
+						i += 1
+						delim.WriteByte(line[i])
+					}
+				default:
+					delim.WriteByte(line[i])
+				}
+			}
+			i -= 1
+			if delim.Len() > 0 {
+				h.delim = delim.String()
+				res = append(res, h)
+			}
+		}
+	}
+	return res, quotes
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The name of the quarantine file, which is looked for next to the bats
+// directory.
+const QuarantineFileName = ".lambdabats-quarantine"
+
+// A known-flaky test. Quarantined tests still run, but their failures do
+// not fail the run.
+type QuarantineEntry struct {
+	File   string `json:"file"`
+	Test   string `json:"test"`
+	Owner  string `json:"owner"`
+	Reason string `json:"reason"`
+}
+
+// A quarantine entry is flagged as stale once its test passes in this many
+// runs in a row.
+const quarantineStaleRuns = 5
+
+// How many of the most recent runs in the history are looked through for
+// the earlier passes of quarantined tests.
+const quarantineHistoryRuns = 50
+
+func DefaultQuarantinePath(batsDir string) string {
+	return filepath.Join(filepath.Dir(batsDir), QuarantineFileName)
+}
+
+// Load the quarantine file at |path|, which is a JSON array of
+// QuarantineEntry. A missing file is an empty quarantine.
+func LoadQuarantine(path string) ([]QuarantineEntry, error) {
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []QuarantineEntry
+	err = json.Unmarshal(bs, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	for i, e := range entries {
+		if e.File == "" || e.Test == "" {
+			return nil, fmt.Errorf("could not parse %s: entry %d must have a file and a test", path, i)
+		}
+	}
+	return entries, nil
+}
+
+// Mark the tests in |files| which are in |entries| as quarantined.
+func ApplyQuarantine(files []TestFile, entries []QuarantineEntry) {
+	byTest := make(map[string]map[string]*QuarantineEntry)
+	for i, e := range entries {
+		if byTest[e.File] == nil {
+			byTest[e.File] = make(map[string]*QuarantineEntry)
+		}
+		byTest[e.File][e.Test] = &entries[i]
+	}
+	for fi := range files {
+		for ti := range files[fi].Tests {
+			t := &files[fi].Tests[ti]
+			t.Quarantine = byTest[files[fi].Name][t.Name]
+		}
+	}
+}
+
+// The quarantine entries which do not match any test, probably because of a
+// typo or because the test was renamed or removed. |loaded| are the test
+// files which were loaded, before any filtering. An entry for a file which
+// was not loaded is only reported if the file does not exist in |batsDir|.
+func UnmatchedQuarantineEntries(batsDir string, loaded []TestFile, entries []QuarantineEntry) []QuarantineEntry {
+	tests := make(map[string]map[string]bool)
+	for _, f := range loaded {
+		tests[f.Name] = make(map[string]bool)
+		for _, t := range f.Tests {
+			tests[f.Name][t.Name] = true
+		}
+	}
+	var res []QuarantineEntry
+	for _, e := range entries {
+		if names, ok := tests[e.File]; ok {
+			if !names[e.Test] {
+				res = append(res, e)
+			}
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(batsDir, e.File)); errors.Is(err, os.ErrNotExist) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// The quarantine entries for tests which passed in this run and in each of
+// the runs of them in |history| before it, going back far enough to make
+// quarantineStaleRuns runs in a row. They are probably not flaky anymore.
+func StaleQuarantineEntries(files []TestFile, history []HistoryRun) []QuarantineEntry {
+	var res []QuarantineEntry
+	for _, f := range files {
+		for _, t := range f.Tests {
+			if t.Quarantine == nil || len(t.Runs) == 0 || t.Summary().Status != TestStatus_Passed {
+				continue
+			}
+			passes := 1
+			timeline := TestTimeline(history, f.Name, t.Name)
+			for i := len(timeline) - 1; i >= 0 && passes < quarantineStaleRuns; i-- {
+				if timeline[i].Test.Status != "passed" {
+					break
+				}
+				passes += 1
+			}
+			if passes >= quarantineStaleRuns {
+				res = append(res, *t.Quarantine)
+			}
+		}
+	}
+	return res
+}
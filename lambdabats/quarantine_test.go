@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	batsDir := filepath.Join(dir, "integration-tests", "bats")
+	path := DefaultQuarantinePath(batsDir)
+	assert.Equal(t, filepath.Join(dir, "integration-tests", ".lambdabats-quarantine"), path)
+
+	entries, err := LoadQuarantine(path)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(`[
+  {"file": "a.bats", "test": "a: flaky", "owner": "dustin", "reason": "races with the server starting"},
+  {"file": "a.bats", "test": "a: fixed", "owner": "aaron"}
+]`), 0644))
+	entries, err = LoadQuarantine(path)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	failed := TestRun{Response: wire.RunTestResult{Err: "exit status 1", Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0">
+<testsuite name="a.bats" tests="1" failures="1" errors="0" skipped="0" time="0">
+    <testcase classname="a.bats" name="a: flaky" time="0">
+        <failure type="failure">(in test file a.bats, line 3)</failure>
+    </testcase>
+</testsuite>
+</testsuites>
+`}}
+	passed := TestRun{Response: wire.RunTestResult{Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0">
+<testsuite name="a.bats" tests="1" failures="0" errors="0" skipped="0" time="0">
+    <testcase classname="a.bats" name="a: fixed" time="0" />
+</testsuite>
+</testsuites>
+`}}
+	files := []TestFile{{Name: "a.bats", Tests: []Test{
+		{Name: "a: flaky", Runs: []TestRun{failed}},
+		{Name: "a: fixed", Runs: []TestRun{passed}},
+	}}}
+	assert.Equal(t, 1, ExitCode(files, RunInfo{}))
+	ApplyQuarantine(files, entries)
+	assert.Equal(t, "dustin", files[0].Tests[0].Quarantine.Owner)
+	assert.Equal(t, 0, ExitCode(files, RunInfo{}))
+
+	// a: fixed needs quarantineStaleRuns passes in a row, including this
+	// run, to be stale.
+	var history []HistoryRun
+	for i := 0; i < quarantineStaleRuns-1; i++ {
+		assert.Empty(t, StaleQuarantineEntries(files, history))
+		history = append(history, HistoryRun{Tests: []HistoryTest{
+			{File: "a.bats", Name: "a: flaky", Status: "passed"},
+			{File: "a.bats", Name: "a: fixed", Status: "passed"},
+		}})
+	}
+	assert.Equal(t, []QuarantineEntry{entries[1]}, StaleQuarantineEntries(files, history))
+	history[1].Tests[1].Status = "flaky"
+	assert.Empty(t, StaleQuarantineEntries(files, history))
+
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"file": "a.bats"}]`), 0644))
+	_, err = LoadQuarantine(path)
+	assert.Error(t, err)
+}
+
+func TestUnmatchedQuarantineEntries(t *testing.T) {
+	batsDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(batsDir, "b.bats"), []byte("@test \"b: one\" {\n}\n"), 0644))
+	loaded := []TestFile{{Name: "a.bats", Tests: []Test{{Name: "a: one"}}}}
+	entries := []QuarantineEntry{
+		{File: "a.bats", Test: "a: one"},
+		{File: "a.bats", Test: "a: renamed"},
+		// b.bats was not loaded, but it exists, so its entries are not
+		// checked.
+		{File: "b.bats", Test: "b: anything"},
+		{File: "removed.bats", Test: "removed: one"},
+	}
+	assert.Equal(t, []QuarantineEntry{entries[1], entries[3]}, UnmatchedQuarantineEntries(batsDir, loaded, entries))
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// A hash of the paths, modes and contents of all the files under |dir|,
+// which changes whenever anything which would end up in the test artifacts
+// built from it changes.
+func HashDir(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00", filepath.ToSlash(rel), info.Mode())
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, target)
+		case d.Type().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fmt.Fprintf(h, "%d\x00", info.Size())
+			_, err = io.Copy(h, f)
+			if err != nil {
+				return err
+			}
+		}
+		h.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Hashes of the sources the test artifacts are built from: the dolt go
+// module, which dolt and remotesrv are built from, and the bats tests.
+type SourceHashes struct {
+	Dolt string `json:"dolt"`
+	Bats string `json:"bats"`
+}
+
+// Returns true if both the dolt sources and the bats tests were hashed.
+func (h SourceHashes) Complete() bool {
+	return h.Dolt != "" && h.Bats != ""
+}
+
+func HashSources(doltSrcDir, batsDir string) (SourceHashes, error) {
+	var res SourceHashes
+	var err error
+	res.Dolt, err = HashDir(filepath.Join(doltSrcDir, "go"))
+	if err != nil {
+		return SourceHashes{}, err
+	}
+	res.Bats, err = HashDir(batsDir)
+	if err != nil {
+		return SourceHashes{}, err
+	}
+	return res, nil
+}
+
+// The most recent run in the history. Only its record is read, skipping
+// back past any newer records which cannot be read.
+func LoadLastHistoryRun() (HistoryRun, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return HistoryRun{}, err
+	}
+	ids, err := historyRunIDs(dir)
+	if err != nil {
+		return HistoryRun{}, err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		run, err := LoadHistoryRun(ids[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping unreadable history record %s.json: %v\n", ids[i], err)
+			continue
+		}
+		return run, nil
+	}
+	return HistoryRun{}, ErrNoRecordedRun
+}
+
+// The tests which failed, were fatal or timed out in |r|, keyed by file and
+// then test name, for use as TestFilter.Only.
+func (r HistoryRun) FailedTests() map[string]map[string]bool {
+	res := make(map[string]map[string]bool)
+	for _, t := range r.Tests {
+		if t.Status != "failed" && t.Status != "fatal" && t.Status != "timeout" {
+			continue
+		}
+		if res[t.File] == nil {
+			res[t.File] = make(map[string]bool)
+		}
+		res[t.File][t.Name] = true
+	}
+	return res
+}
+
+// Returns true if the artifacts uploaded for |r| can be used for a run with
+// |config|, instead of building and uploading them again. They can be when
+// they were built the same way from the same sources, and uploaded for the
+// same execution strategy. They never can be if either run could not hash
+// its sources.
+func (r HistoryRun) CanReuseArtifacts(config HistoryRunConfig) bool {
+	if r.Artifacts == (JSONArtifacts{}) || !r.Sources.Complete() || !config.Sources.Complete() {
+		return false
+	}
+	return r.Sources == config.Sources && r.Strategy == config.Strategy && r.Arch == config.Arch && r.Race == config.Race
+}
+
+func (r HistoryRun) UploadLocations() UploadLocations {
+	return UploadLocations{
+		DoltPath:  r.Artifacts.Dolt,
+		BinPath:   r.Artifacts.Bin,
+		TestsPath: r.Artifacts.Tests,
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "helper"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.bats"), []byte("@test \"a\" {\n}\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "helper", "common.bash"), []byte("f() { :; }\n"), 0644))
+
+	first, err := HashDir(dir)
+	assert.NoError(t, err)
+	again, err := HashDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "helper", "common.bash"), []byte("f() { true; }\n"), 0644))
+	changed, err := HashDir(dir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, changed)
+
+	assert.NoError(t, os.Chmod(filepath.Join(dir, "a.bats"), 0755))
+	chmoded, err := HashDir(dir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, changed, chmoded)
+}
+
+func TestRerunFailed(t *testing.T) {
+	run := HistoryRun{
+		Artifacts: JSONArtifacts{Dolt: "DOLT", Bin: "BIN", Tests: "TESTS"},
+		Sources:   SourceHashes{Dolt: "dolt", Bats: "bats"},
+		Strategy:  "lambda",
+		Arch:      "arm64",
+		Tests: []HistoryTest{
+			{File: "a.bats", Name: "passed", Status: "passed"},
+			{File: "a.bats", Name: "failed", Status: "failed"},
+			{File: "b.bats", Name: "fatal", Status: "fatal"},
+			{File: "b.bats", Name: "skipped", Status: "skipped"},
+		},
+	}
+	assert.Equal(t, map[string]map[string]bool{
+		"a.bats": {"failed": true},
+		"b.bats": {"fatal": true},
+	}, run.FailedTests())
+
+	config := HistoryRunConfig{Sources: run.Sources, Strategy: "lambda", Arch: "arm64"}
+	assert.True(t, run.CanReuseArtifacts(config))
+	assert.Equal(t, UploadLocations{DoltPath: "DOLT", BinPath: "BIN", TestsPath: "TESTS"}, run.UploadLocations())
+
+	changed := config
+	changed.Sources.Bats = "changed"
+	assert.False(t, run.CanReuseArtifacts(changed))
+	changed = config
+	changed.Race = true
+	assert.False(t, run.CanReuseArtifacts(changed))
+	changed = config
+	changed.Strategy = "lambda_emulator"
+	assert.False(t, run.CanReuseArtifacts(changed))
+	changed = config
+	changed.Sources = SourceHashes{}
+	run.Sources = SourceHashes{}
+	assert.False(t, run.CanReuseArtifacts(changed))
+	// Neither run could hash the bats tests.
+	changed.Sources = SourceHashes{Dolt: "dolt"}
+	run.Sources = SourceHashes{Dolt: "dolt"}
+	assert.False(t, run.CanReuseArtifacts(changed))
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/aws/smithy-go"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+// Returns true if |err| came from the infrastructure we run the tests on,
+// as opposed to the test itself. These are things like Lambda throttling
+// us, the Lambda function crashing, the connection to it being reset, or the
+// server failing to download the test artifacts from S3. They are worth
+// retrying.
+//
+// The Lambda function timing out is not retried, since it is usually a hung
+// test which would only hang again. Neither are 4xx responses from the
+// server other than 429, which mean the request itself was bad.
+func IsInfraError(err error) bool {
+	var fnErr *LambdaFunctionError
+	if errors.As(err, &fnErr) {
+		return !fnErr.TimedOut()
+	}
+	var statusErr *LambdaStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "TooManyRequestsException", "ThrottlingException", "ServiceException", "EC2ThrottledException":
+			return true
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// The connection to the function being dropped part way through.
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return false
+}
+
+// A Runner which retries test runs which fail with infrastructure errors,
+// with exponential backoff between the attempts.
+type RetryingRunner struct {
+	runner  Runner
+	retries int
+
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+var _ Runner = (*RetryingRunner)(nil)
+
+// Retry each test run up to |retries| times.
+func NewRetryingRunner(runner Runner, retries int) *RetryingRunner {
+	return &RetryingRunner{
+		runner:     runner,
+		retries:    retries,
+		backoff:    1 * time.Second,
+		maxBackoff: 30 * time.Second,
+	}
+}
+
+func (r *RetryingRunner) Run(ctx context.Context, req wire.RunTestRequest) (wire.RunTestResult, error) {
+	res, _, err := r.RunWithRetries(ctx, req)
+	return res, err
+}
+
+// Like Run, but also returns the errors from each attempt which was retried.
+// If the test run still fails with an infrastructure error after all the
+// retries are used up, that error is returned.
+func (r *RetryingRunner) RunWithRetries(ctx context.Context, req wire.RunTestRequest) (wire.RunTestResult, []string, error) {
+	var retried []string
+	backoff := r.backoff
+	for {
+		res, err := r.runner.Run(ctx, req)
+		if err == nil || !IsInfraError(err) || len(retried) == r.retries {
+			return res, retried, err
+		}
+		retried = append(retried, err.Error())
+
+		// Full jitter, so that a burst of throttled invocations does not
+		// come back all at once.
+		select {
+		case <-ctx.Done():
+			return res, retried, ctx.Err()
+		case <-time.After(rand.N(backoff)):
+		}
+		backoff = min(backoff*2, r.maxBackoff)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+type fakeRunner struct {
+	errs []error
+	runs int
+}
+
+func (f *fakeRunner) Run(ctx context.Context, req wire.RunTestRequest) (wire.RunTestResult, error) {
+	f.runs += 1
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return wire.RunTestResult{}, err
+	}
+	return wire.RunTestResult{Output: "ok"}, nil
+}
+
+func TestIsInfraError(t *testing.T) {
+	// How the HTTP client reports the connection to the function failing.
+	connErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://function.lambda-url.us-west-2.on.aws/", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"function error", &LambdaFunctionError{FunctionError: "Unhandled"}, true},
+		{"function timeout", &LambdaFunctionError{FunctionError: "Unhandled", Payload: `{"errorMessage":"2023-10-01T12:00:00.000Z 1234 Task timed out after 900.00 seconds"}`}, false},
+		{"503", &LambdaStatusError{StatusCode: 503}, true},
+		{"429", &LambdaStatusError{StatusCode: 429}, true},
+		{"400", &LambdaStatusError{StatusCode: 400, Body: "must supply test_name"}, false},
+		{"connection reset", connErr(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"broken pipe", connErr(&net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}), true},
+		{"unexpected EOF", connErr(io.ErrUnexpectedEOF), true},
+		{"connection refused", connErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), false},
+		{"other error", errors.New("some other error"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, IsInfraError(test.err))
+		})
+	}
+}
+
+func TestRetryingRunner(t *testing.T) {
+	infraErr := &LambdaFunctionError{FunctionError: "Unhandled"}
+	fake := &fakeRunner{errs: []error{infraErr, infraErr}}
+	r := NewRetryingRunner(fake, 3)
+	r.backoff = time.Millisecond
+	res, retried, err := r.RunWithRetries(context.Background(), wire.RunTestRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", res.Output)
+	assert.Len(t, retried, 2)
+	assert.Equal(t, 3, fake.runs)
+
+	fake = &fakeRunner{errs: []error{infraErr, infraErr, infraErr}}
+	r = NewRetryingRunner(fake, 2)
+	r.backoff = time.Millisecond
+	_, retried, err = r.RunWithRetries(context.Background(), wire.RunTestRequest{})
+	assert.ErrorIs(t, err, infraErr)
+	assert.Len(t, retried, 2)
+
+	otherErr := errors.New("some other error")
+	fake = &fakeRunner{errs: []error{otherErr}}
+	r = NewRetryingRunner(fake, 2)
+	_, retried, err = r.RunWithRetries(context.Background(), wire.RunTestRequest{})
+	assert.ErrorIs(t, err, otherErr)
+	assert.Len(t, retried, 0)
+}
@@ -0,0 +1,284 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+// Sends tests to the appropriate Runner and collects their results.
+type Dispatcher struct {
+	Artifacts   UploadLocations
+	Lambda      *RetryingRunner
+	Local       *RetryingRunner
+	Concurrency int
+
+	// If non-nil, hedges slow test runs in Lambda.
+	Hedger *Hedger
+
+	// How long each test may run before it is killed, unless it has a
+	// timeout tag. Zero means no limit.
+	TestTimeout time.Duration
+
+	// If non-zero, stop starting tests once this many of them have
+	// failed; see --fail-fast.
+	FailFast int
+
+	// If non-nil, each run is reported to it as soon as it completes; see
+	// --stream.
+	Stream *ResultStream
+
+	failedMu sync.Mutex
+	failed   map[*Test]bool
+
+	// One for each test invocation in flight, including hedges, so that
+	// there are never more than Concurrency of them.
+	slotsOnce sync.Once
+	slots     chan struct{}
+}
+
+func (d *Dispatcher) runSlots() chan struct{} {
+	d.slotsOnce.Do(func() {
+		d.slots = make(chan struct{}, d.Concurrency)
+	})
+	return d.slots
+}
+
+// The value of --fail-fast. It can be given without a value, to stop after
+// the first failure.
+type FailFastFlag int
+
+func (f *FailFastFlag) String() string {
+	return strconv.Itoa(int(*f))
+}
+
+func (f *FailFastFlag) Set(s string) error {
+	switch s {
+	case "true":
+		*f = 1
+	case "false":
+		*f = 0
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("expected a number of failures, got %q", s)
+		}
+		*f = FailFastFlag(n)
+	}
+	return nil
+}
+
+func (f *FailFastFlag) IsBoolFlag() bool {
+	return true
+}
+
+// Remember that |run| of |t| failed, if it did. Returns true if that makes
+// enough failed tests to stop the run for FailFast.
+func (d *Dispatcher) recordFailure(t *Test, run TestRun) bool {
+	if d.FailFast == 0 || t.Quarantine != nil {
+		return false
+	}
+	if _, failed := run.FailureOutput(t.Name); !failed {
+		return false
+	}
+	d.failedMu.Lock()
+	defer d.failedMu.Unlock()
+	if d.failed == nil {
+		d.failed = make(map[*Test]bool)
+	}
+	d.failed[t] = true
+	return len(d.failed) >= d.FailFast
+}
+
+// True once FailFast tests have failed. No more tests are started after
+// that.
+func (d *Dispatcher) FailedFast() bool {
+	if d.FailFast == 0 {
+		return false
+	}
+	d.failedMu.Lock()
+	defer d.failedMu.Unlock()
+	return len(d.failed) >= d.FailFast
+}
+
+// How much longer than a test's timeout we wait for a Lambda invocation of it
+// to come back before giving up on it ourselves. This covers downloading and
+// unpacking the test artifacts, which happens before bats starts.
+const lambdaTimeoutGrace = 2 * time.Minute
+
+// Run each of |tests| concurrently, appending the TestRun to each test as it
+// completes. A test which appears in |tests| more than once is run more than
+// once.
+//
+// Once |ctx| is done, or FailFast tests have failed, the rest of |tests|
+// are not started, and the tests which are already running are left to
+// finish, so that their results are not lost. Tests which were never started
+// have no runs.
+func (d *Dispatcher) RunTests(ctx context.Context, tests []*Test, bar *progressbar.ProgressBar) {
+	var mu sync.Mutex
+	runCtx := context.WithoutCancel(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if d.FailedFast() {
+		cancel()
+	}
+	slots := d.runSlots()
+	eg, egCtx := errgroup.WithContext(ctx)
+dispatch:
+	for _, t := range tests {
+		// Wait for a free slot. A hedge may be holding one.
+		select {
+		case slots <- struct{}{}:
+		case <-egCtx.Done():
+			break dispatch
+		}
+		if egCtx.Err() != nil {
+			<-slots
+			break
+		}
+		eg.Go(func() error {
+			defer func() { <-slots }()
+			run := d.RunTest(runCtx, t)
+			mu.Lock()
+			t.Runs = append(t.Runs, run)
+			mu.Unlock()
+			if d.Stream != nil {
+				d.Stream.Add(t, run)
+			}
+			bar.Add(1)
+			if d.recordFailure(t, run) {
+				cancel()
+			}
+			return nil
+		})
+	}
+	eg.Wait()
+}
+
+// Rerun each of |tests| up to |n| more times, one pass after another, to
+// tell flaky tests from broken ones. A test is not retried again once one of
+// its runs has passed, since that already shows that it is flaky. |bar|
+// starts out with room for every retry, and its maximum is lowered as tests
+// drop out.
+func (d *Dispatcher) RetryFailures(ctx context.Context, tests []*Test, n int, bar *progressbar.ProgressBar) {
+	done := 0
+	for pass := range n {
+		if ctx.Err() != nil || d.FailedFast() {
+			return
+		}
+		var failing []*Test
+		for _, t := range tests {
+			if t.Summary().Passed == 0 {
+				failing = append(failing, t)
+			}
+		}
+		bar.ChangeMax(done + len(failing)*(n-pass))
+		if len(failing) == 0 {
+			return
+		}
+		d.RunTests(ctx, failing, bar)
+		done += len(failing)
+	}
+}
+
+// Run |t| once. An error running it, as opposed to the test failing, such as
+// a Lambda invocation failing after all its retries, is reported as a fatal
+// run with InfraErr set, so that one test cannot stop the whole run.
+func (d *Dispatcher) RunTest(ctx context.Context, t *Test) TestRun {
+	req := wire.RunTestRequest{
+		DoltLocation: d.Artifacts.DoltPath,
+		BinLocation:  d.Artifacts.BinPath,
+		BatsLocation: d.Artifacts.TestsPath,
+		FileName:     t.File.Name,
+		TestName:     t.Name,
+		TestFilter:   EscapeNameForFilter(t.Name),
+		EnvVars:      EnvVars,
+	}
+	timeout := t.TimeoutOr(d.TestTimeout)
+	if timeout > 0 {
+		req.TimeoutSeconds = int((timeout + time.Second - 1) / time.Second)
+	}
+	runner := d.Lambda
+	if t.HasTag("no_lambda") {
+		runner = d.Local
+	}
+	run := func(ctx context.Context) (TestRun, error) {
+		begin := time.Now()
+		invokeCtx := ctx
+		// The server enforces the timeout, but if it cannot, for example
+		// because the invocation is stuck, do not wait on it forever.
+		if timeout > 0 && runner == d.Lambda {
+			var cancel context.CancelFunc
+			invokeCtx, cancel = context.WithTimeout(ctx, timeout+lambdaTimeoutGrace)
+			defer cancel()
+		}
+		resp, retries, err := runner.RunWithRetries(invokeCtx, req)
+		if err != nil && ctx.Err() == nil && errors.Is(invokeCtx.Err(), context.DeadlineExceeded) {
+			resp = wire.RunTestResult{
+				Err:      fmt.Sprintf("timed out after %v waiting for the Lambda invocation: %v", timeout+lambdaTimeoutGrace, err),
+				TimedOut: true,
+			}
+			err = nil
+		}
+		// The Lambda function timing out is not retried, and is reported
+		// like the server's own timeout rather than as an infrastructure
+		// error.
+		var fnErr *LambdaFunctionError
+		if errors.As(err, &fnErr) && fnErr.TimedOut() {
+			resp = wire.RunTestResult{Err: err.Error(), TimedOut: true}
+			err = nil
+		}
+		res := TestRun{
+			Response:     resp,
+			Runner:       RunnerName(runner),
+			InfraRetries: retries,
+			Latency:      time.Since(begin),
+		}
+		if err != nil {
+			res.Response = wire.RunTestResult{Err: err.Error()}
+			res.InfraErr = err.Error()
+		}
+		return res, err
+	}
+	// Only one test can run locally at a time, so there is nothing to
+	// gain from hedging there.
+	if d.Hedger != nil && runner == d.Lambda {
+		res, _ := d.Hedger.Run(ctx, HedgeKey(t), d.runSlots(), run)
+		return res
+	}
+	res, _ := run(ctx)
+	return res
+}
+
+// Returns pointers to all the tests in |files|, for passing to RunTests.
+func AllTests(files []TestFile) []*Test {
+	var res []*Test
+	for fi := range files {
+		for ti := range files[fi].Tests {
+			res = append(res, &files[fi].Tests[ti])
+		}
+	}
+	return res
+}
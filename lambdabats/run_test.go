@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/schollz/progressbar/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+// A Runner which blocks each run until it is released, so that tests can
+// control what is in flight.
+type blockingRunner struct {
+	mu      sync.Mutex
+	started []string
+	start   chan string
+	release chan struct{}
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{start: make(chan string, 100), release: make(chan struct{})}
+}
+
+func (r *blockingRunner) Run(ctx context.Context, req wire.RunTestRequest) (wire.RunTestResult, error) {
+	r.mu.Lock()
+	r.started = append(r.started, req.TestName)
+	r.mu.Unlock()
+	r.start <- req.TestName
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return wire.RunTestResult{}, ctx.Err()
+	}
+	return wire.RunTestResult{Output: SkippedJUnitTestCaseOutput(req.FileName, req.TestName, "done")}, nil
+}
+
+func newTestDispatcher(r Runner, concurrency int) *Dispatcher {
+	return &Dispatcher{
+		Lambda:      NewRetryingRunner(r, 0),
+		Local:       NewRetryingRunner(r, 0),
+		Concurrency: concurrency,
+	}
+}
+
+func silentBar(n int) *progressbar.ProgressBar {
+	return progressbar.NewOptions(n, progressbar.OptionSetWriter(io.Discard))
+}
+
+func TestDispatcherInterrupted(t *testing.T) {
+	runner := newBlockingRunner()
+	d := newTestDispatcher(runner, 2)
+	files := []TestFile{{Name: "a.bats", Tests: []Test{{Name: "a: 1"}, {Name: "a: 2"}, {Name: "a: 3"}, {Name: "a: 4"}}}}
+	for i := range files[0].Tests {
+		files[0].Tests[i].File = files[0]
+	}
+	tests := AllTests(files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.RunTests(ctx, tests, silentBar(len(tests)))
+		close(done)
+	}()
+	// Interrupt once the first two tests are running. They are left to
+	// finish, and nothing else is started.
+	<-runner.start
+	<-runner.start
+	cancel()
+	close(runner.release)
+	<-done
+
+	assert.Len(t, runner.started, 2)
+	var ran, notRun int
+	for _, test := range tests {
+		s := test.Summary()
+		if s.Status == TestStatus_NotRun {
+			notRun += 1
+		} else {
+			ran += 1
+			assert.Equal(t, TestStatus_Skipped, s.Status)
+		}
+	}
+	assert.Equal(t, 2, ran)
+	assert.Equal(t, 2, notRun)
+
+	info := RunInfo{Interrupted: true}
+	assert.Equal(t, 1, ExitCode(files, info))
+}
+
+// A Runner which fails the tests named in |fail|, fails the tests named in
+// |failFirst| on that many of their first runs, returns the errors in |errs|
+// for the tests named there, and passes the rest.
+type scriptedRunner struct {
+	mu        sync.Mutex
+	fail      map[string]bool
+	failFirst map[string]int
+	errs      map[string]error
+	runs      []string
+}
+
+func (r *scriptedRunner) Run(ctx context.Context, req wire.RunTestRequest) (wire.RunTestResult, error) {
+	r.mu.Lock()
+	previous := 0
+	for _, name := range r.runs {
+		if name == req.TestName {
+			previous += 1
+		}
+	}
+	r.runs = append(r.runs, req.TestName)
+	r.mu.Unlock()
+	if err := r.errs[req.TestName]; err != nil {
+		return wire.RunTestResult{}, err
+	}
+	if r.fail[req.TestName] || previous < r.failFirst[req.TestName] {
+		return wire.RunTestResult{Err: "exit status 1", Output: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites><testsuite name="` + req.FileName + `"><testcase name="` + req.TestName + `"><failure>boom</failure></testcase></testsuite></testsuites>`}, nil
+	}
+	return wire.RunTestResult{Output: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites><testsuite name="` + req.FileName + `"><testcase name="` + req.TestName + `" /></testsuite></testsuites>`}, nil
+}
+
+func TestDispatcherFailFast(t *testing.T) {
+	var f FailFastFlag
+	assert.NoError(t, f.Set("true"))
+	assert.Equal(t, FailFastFlag(1), f)
+	assert.NoError(t, f.Set("3"))
+	assert.Equal(t, FailFastFlag(3), f)
+	assert.Error(t, f.Set("soon"))
+
+	runner := &scriptedRunner{fail: map[string]bool{"a: 2": true, "a: 3": true, "a: 4": true}}
+	d := newTestDispatcher(runner, 1)
+	d.FailFast = 2
+	files := []TestFile{{Name: "a.bats", Tests: []Test{{Name: "a: 1"}, {Name: "a: 2"}, {Name: "a: 3"}, {Name: "a: 4"}, {Name: "a: 5"}}}}
+	tests := AllTests(files)
+	d.RunTests(context.Background(), tests, silentBar(len(tests)))
+	assert.Equal(t, []string{"a: 1", "a: 2", "a: 3"}, runner.runs)
+	assert.True(t, d.FailedFast())
+	assert.Equal(t, TestStatus_NotRun, tests[3].Summary().Status)
+	assert.Equal(t, TestStatus_NotRun, tests[4].Summary().Status)
+
+	// Later passes do not start anything either.
+	d.RunTests(context.Background(), tests, silentBar(len(tests)))
+	assert.Len(t, runner.runs, 3)
+
+	info := RunInfo{FailedFast: 2}
+	assert.Contains(t, info.StoppedWarning(), "--fail-fast after 2 failed tests")
+}
+
+func TestDispatcherRunnerErrors(t *testing.T) {
+	runner := &scriptedRunner{errs: map[string]error{
+		"a: 2": errors.New("connection reset by peer"),
+		"a: 4": &LambdaFunctionError{FunctionError: "Unhandled", Payload: `{"errorMessage":"2024-01-01T00:00:00Z Task timed out after 900.10 seconds"}`},
+	}}
+	d := newTestDispatcher(runner, 2)
+	files := []TestFile{{Name: "a.bats", Tests: []Test{{Name: "a: 1"}, {Name: "a: 2"}, {Name: "a: 3"}, {Name: "a: 4"}}}}
+	tests := AllTests(files)
+	d.RunTests(context.Background(), tests, silentBar(len(tests)))
+
+	assert.Len(t, runner.runs, 4)
+	assert.Equal(t, TestStatus_Passed, tests[0].Summary().Status)
+	assert.Equal(t, TestStatus_Passed, tests[2].Summary().Status)
+
+	// The Lambda function timing out is a timeout, not an infrastructure
+	// error.
+	assert.Equal(t, TestStatus_TimedOut, tests[3].Summary().Status)
+	assert.Empty(t, infraErrors(*tests[3]))
+	s := tests[1].Summary()
+	assert.Equal(t, TestStatus_Fatal, s.Status)
+	assert.ErrorContains(t, s.FailureErr, "connection reset by peer")
+	assert.Equal(t, []string{"connection reset by peer"}, infraErrors(*tests[1]))
+
+	run := NewJSONTestRun(tests[1].Name, tests[1].Runs[0])
+	assert.Equal(t, "fatal", run.Status)
+	assert.True(t, run.InfraError)
+}
+
+func TestDispatcherStream(t *testing.T) {
+	runner := &scriptedRunner{
+		fail: map[string]bool{"a: 2": true},
+		errs: map[string]error{"a: 3": errors.New("connection reset by peer")},
+	}
+	d := newTestDispatcher(runner, 2)
+	files := []TestFile{{Name: "a.bats", Tests: []Test{{Name: "a: 1"}, {Name: "a: 2"}, {Name: "a: 3"}, {Name: "a: 4"}}}}
+	for i := range files[0].Tests {
+		files[0].Tests[i].File = files[0]
+	}
+	tests := AllTests(files)
+
+	var out bytes.Buffer
+	bar := progressbar.NewOptions(len(tests), progressbar.OptionSetWriter(&out), progressbar.OptionSetDescription("running tests"))
+	d.Stream = NewResultStream(bar)
+	d.RunTests(context.Background(), tests, bar)
+
+	assert.Equal(t, "running tests (2 passed, 2 failed, 0 skipped)", bar.State().Description)
+	assert.Contains(t, out.String(), "✗ a.bats: a: 2\n  boom\n")
+	assert.Contains(t, out.String(), "✗ a.bats: a: 3 (fatal)\n  connection reset by peer\n")
+	assert.NotContains(t, out.String(), "a.bats: a: 1")
+}
+
+func TestDispatcherRetryFailures(t *testing.T) {
+	runner := &scriptedRunner{
+		fail:      map[string]bool{"a: broken": true},
+		failFirst: map[string]int{"a: flaky": 2},
+	}
+	d := newTestDispatcher(runner, 1)
+	files := []TestFile{{Name: "a.bats", Tests: []Test{{Name: "a: broken"}, {Name: "a: flaky"}}}}
+	tests := AllTests(files)
+	d.RunTests(context.Background(), tests, silentBar(len(tests)))
+
+	bar := silentBar(len(tests) * 3)
+	d.RetryFailures(context.Background(), tests, 3, bar)
+	// The flaky test is not retried again after it passes on its second
+	// retry.
+	assert.Equal(t, []string{"a: broken", "a: flaky", "a: broken", "a: flaky", "a: broken", "a: flaky", "a: broken"}, runner.runs)
+	assert.Equal(t, 5, bar.GetMax())
+	assert.Equal(t, "failed consistently (4/4)", runsNote(tests[0].Summary()))
+	assert.Equal(t, "flaky (passed 1/3)", runsNote(tests[1].Summary()))
+}
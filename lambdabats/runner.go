@@ -18,12 +18,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -60,14 +64,34 @@ func NewLocalRunner(batsDir string) *LocalRunner {
 func (r *LocalRunner) Run(ctx context.Context, req wire.RunTestRequest) (wire.RunTestResult, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	cmd := exec.Command("bats")
+	// The timeout starts once the test has its turn to run.
+	cmdCtx := ctx
+	if req.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(cmdCtx, "bats")
 	cmd.Env = os.Environ()
 	cmd.Env = append(cmd.Env, EnvVars...)
 	cmd.Dir = r.batsDir
 	cmd.Args = []string{
 		"bats", "-F", "junit", "-f", req.TestFilter, req.FileName,
 	}
+	// As in the server, kill everything the test started, not just bats.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 10 * time.Second
 	output, err := cmd.CombinedOutput()
+	if req.TimeoutSeconds > 0 && errors.Is(cmdCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return wire.RunTestResult{
+			Output:   string(output),
+			Err:      fmt.Sprintf("timed out after %ds", req.TimeoutSeconds),
+			TimedOut: true,
+		}, nil
+	}
 	if err != nil {
 		return wire.RunTestResult{
 			Output: string(output),
@@ -83,6 +107,22 @@ type Runner interface {
 	Run(ctx context.Context, req wire.RunTestRequest) (wire.RunTestResult, error)
 }
 
+// A short name for where |r| runs tests, for reporting: lambda, local or
+// skip.
+func RunnerName(r Runner) string {
+	switch r := r.(type) {
+	case *RetryingRunner:
+		return RunnerName(r.runner)
+	case SkipRunner:
+		return "skip"
+	case *LocalRunner:
+		return "local"
+	case *LambdaInvokeRunner, *LambdaEmulatorRunner:
+		return "lambda"
+	}
+	return "unknown"
+}
+
 // A runner which calls our local lambda emulator.
 type LambdaEmulatorRunner struct {
 	endpointURL string
@@ -150,9 +190,43 @@ func (e *LambdaInvokeRunner) Run(ctx context.Context, req wire.RunTestRequest) (
 	if err != nil {
 		return res, err
 	}
+	if resp.FunctionError != nil {
+		return res, &LambdaFunctionError{
+			FunctionError: *resp.FunctionError,
+			Payload:       string(resp.Payload),
+		}
+	}
 	return FromLambdaFunctionURLHTTPReResponseBytes(resp.Payload)
 }
 
+// The Lambda function failed to return a response at all. For example, it
+// timed out, ran out of memory or returned an error. FunctionError is
+// typically "Unhandled".
+type LambdaFunctionError struct {
+	FunctionError string
+	Payload       string
+}
+
+func (e *LambdaFunctionError) Error() string {
+	return fmt.Sprintf("lambda function error: %s: %s", e.FunctionError, e.Payload)
+}
+
+// Returns true if the function ran past its Lambda timeout, which usually
+// means the test hung rather than that the infrastructure misbehaved.
+func (e *LambdaFunctionError) TimedOut() bool {
+	return strings.Contains(e.Payload, "Task timed out after")
+}
+
+// The Lambda function returned a response with a non-200 status code.
+type LambdaStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *LambdaStatusError) Error() string {
+	return fmt.Sprintf("non-200 status code in lambda response: code: %d, body: %s", e.StatusCode, e.Body)
+}
+
 func ToLambdaFunctionURLHTTPRequestBytes(req wire.RunTestRequest) ([]byte, error) {
 	bodyBytes, err := json.Marshal(req)
 	if err != nil {
@@ -174,8 +248,9 @@ func FromLambdaFunctionURLHTTPReResponseBytes(bs []byte) (wire.RunTestResult, er
 		return res, err
 	}
 	if lambdaResp.StatusCode != 200 {
-		res.Err = fmt.Sprintf("non-200 status code in lambda response: code: %d, body: %s", lambdaResp.StatusCode, string(bs))
-		return res, nil
+		err = &LambdaStatusError{StatusCode: lambdaResp.StatusCode, Body: string(bs)}
+		res.Err = err.Error()
+		return res, err
 	}
 	err = json.Unmarshal([]byte(lambdaResp.Body), &res)
 	return res, err
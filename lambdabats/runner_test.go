@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestLocalRunnerTimeout(t *testing.T) {
+	// A bats which prints something and then hangs on a child process,
+	// the way a test stuck on dolt sql-server would.
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho partial output\nsleep 60 &\nwait\n"
+	if !assert.NoError(t, os.WriteFile(filepath.Join(bin, "bats"), []byte(script), 0755)) {
+		return
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r := NewLocalRunner(t.TempDir())
+	begin := time.Now()
+	res, err := r.Run(context.Background(), wire.RunTestRequest{FileName: "a.bats", TestFilter: "^a$", TimeoutSeconds: 1})
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(begin), 10*time.Second)
+	assert.True(t, res.TimedOut)
+	assert.Equal(t, "timed out after 1s", res.Err)
+	assert.Contains(t, res.Output, "partial output")
+
+	result, err := TestRun{Response: res}.Result("a")
+	assert.NoError(t, err)
+	assert.Equal(t, TestRunResultStatus_TimedOut, int(result.Status))
+	assert.Contains(t, result.Output, "partial output")
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/schollz/progressbar/v3"
+)
+
+// The progress bar for running |max| tests. With |stream|, the bar is
+// redrawn on every update instead of being throttled, so that the results
+// printed above it by a ResultStream show up right away and are not left
+// behind in its buffer if the run is stopped.
+func NewTestProgressBar(max int, description string, stream bool) *progressbar.ProgressBar {
+	if !stream {
+		return progressbar.Default(int64(max), description)
+	}
+	return progressbar.NewOptions(max,
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWriter(os.Stderr),
+		progressbar.OptionSetWidth(10),
+		progressbar.OptionShowTotalBytes(true),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Fprint(os.Stderr, "\n")
+		}),
+		progressbar.OptionSpinnerType(14),
+		progressbar.OptionFullWidth(),
+		progressbar.OptionSetRenderBlankState(true),
+	)
+}
+
+// Reports test runs as they complete, for --stream. Each failing run is
+// printed above the progress bar as soon as it comes back, and the bar's
+// description keeps a count of the runs which have passed, failed and been
+// skipped so far.
+type ResultStream struct {
+	bar         *progressbar.ProgressBar
+	description string
+
+	mu      sync.Mutex
+	passed  int
+	failed  int
+	skipped int
+}
+
+func NewResultStream(bar *progressbar.ProgressBar) *ResultStream {
+	return &ResultStream{bar: bar, description: bar.State().Description}
+}
+
+// Report |run| of |t|.
+func (s *ResultStream) Add(t *Test, run TestRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := run.Result(t.Name)
+	switch {
+	case err == nil && res.Status == TestRunResultStatus_Success:
+		s.passed += 1
+	case err == nil && res.Status == TestRunResultStatus_Skipped:
+		s.skipped += 1
+	default:
+		s.failed += 1
+		progressbar.Bprintf(s.bar, "%s", streamedFailure(t, run, res, err))
+	}
+	s.bar.Describe(fmt.Sprintf("%s (%d passed, %d failed, %d skipped)", s.description, s.passed, s.failed, s.skipped))
+}
+
+// How a failing run is printed as it comes back, in the style of the failures
+// in the results at the end of the run.
+func streamedFailure(t *Test, run TestRun, res TestRunResult, err error) string {
+	c := color.New(color.FgRed)
+	mark := "✗"
+	note := ""
+	if t.Quarantine != nil {
+		c = color.New(color.FgYellow)
+		mark = "!"
+		note = " (quarantined)"
+	} else if err != nil {
+		note = " (fatal)"
+	} else if res.Status == TestRunResultStatus_TimedOut {
+		note = " (timed out)"
+	}
+	var b strings.Builder
+	b.WriteString(c.Sprintf("%s %s: %s%s", mark, t.File.Name, t.Name, note))
+	b.WriteString("\n")
+	output, _ := run.FailureOutput(t.Name)
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		b.WriteString(c.Sprintf("  %s", line))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// A YAML double-quoted scalar. JSON strings are valid YAML.
+func yamlString(s string) string {
+	bs, _ := json.Marshal(s)
+	return string(bs)
+}
+
+// Write the YAML diagnostic block for a test, indented by |indent|. If the
+// test was run more than once, duration_ms is the mean of the runs.
+func writeTAP13Diagnostics(w io.Writer, indent string, t Test, s TestSummary) {
+	var runners []string
+	for _, run := range t.Runs {
+		if run.Runner != "" && !slices.Contains(runners, run.Runner) {
+			runners = append(runners, run.Runner)
+		}
+	}
+	fmt.Fprintf(w, "%s---\n", indent)
+	fmt.Fprintf(w, "%sduration_ms: %d\n", indent, t.MeanDuration().Milliseconds())
+	fmt.Fprintf(w, "%srunner: %s\n", indent, yamlString(strings.Join(runners, ",")))
+	fmt.Fprintf(w, "%sruns: %d\n", indent, s.Runs())
+	fmt.Fprintf(w, "%sattempts: %d\n", indent, s.Attempts())
+	if note := runsNote(s); note != "" {
+		fmt.Fprintf(w, "%smessage: %s\n", indent, yamlString(note))
+	} else if s.Status == TestStatus_TimedOut {
+		fmt.Fprintf(w, "%smessage: %s\n", indent, yamlString("timed out"))
+	}
+	if s.Status == TestStatus_Fatal {
+		fmt.Fprintf(w, "%sseverity: error\n", indent)
+	} else if s.Failure != nil {
+		fmt.Fprintf(w, "%sseverity: fail\n", indent)
+	}
+	if lines := failureOutput(t, s); len(lines) > 0 {
+		fmt.Fprintf(w, "%soutput: |-\n", indent)
+		for _, line := range lines {
+			fmt.Fprintf(w, "%s  %s\n", indent, line)
+		}
+	}
+	fmt.Fprintf(w, "%s...\n", indent)
+}
+
+// Output TAP version 13, with a subtest for each file and YAML diagnostics
+// for each test.
+func OutputTAP13Results(files []TestFile, info RunInfo) int {
+	WriteTAP13Results(os.Stdout, files, info)
+	return ExitCode(files, info)
+}
+
+func WriteTAP13Results(w io.Writer, files []TestFile, info RunInfo) {
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", len(files))
+	for fi, f := range files {
+		fmt.Fprintf(w, "# Subtest: %s\n", f.Name)
+		fmt.Fprintf(w, "    1..%d\n", len(f.Tests))
+		fileOk := true
+		for ti, t := range f.Tests {
+			s := t.Summary()
+			switch {
+			case s.Status == TestStatus_Skipped && s.SkipReason == "":
+				fmt.Fprintf(w, "    ok %d - %s # SKIP\n", ti+1, t.Name)
+			case s.Status == TestStatus_Skipped:
+				fmt.Fprintf(w, "    ok %d - %s # SKIP %s\n", ti+1, t.Name, s.SkipReason)
+			case s.Status == TestStatus_NotRun:
+				fmt.Fprintf(w, "    ok %d - %s # SKIP not run\n", ti+1, t.Name)
+			case t.Quarantine != nil && s.Failing():
+				fmt.Fprintf(w, "    not ok %d - %s # TODO %s\n", ti+1, t.Name, quarantineNote(t.Quarantine))
+			case s.Status == TestStatus_Passed, !info.FailsRun(t, s):
+				fmt.Fprintf(w, "    ok %d - %s\n", ti+1, t.Name)
+			default:
+				fileOk = false
+				fmt.Fprintf(w, "    not ok %d - %s\n", ti+1, t.Name)
+			}
+			writeTAP13Diagnostics(w, "      ", t, s)
+		}
+		if fileOk {
+			fmt.Fprintf(w, "ok %d - %s\n", fi+1, f.Name)
+		} else {
+			fmt.Fprintf(w, "not ok %d - %s\n", fi+1, f.Name)
+		}
+	}
+	if hedges := hedgeSummary(files); hedges != "" {
+		fmt.Fprintf(w, "# %s\n", hedges)
+	}
+	for _, e := range info.StaleQuarantine {
+		fmt.Fprintf(w, "# stale quarantine entry, passed in its last %d runs: %s: %s\n", quarantineStaleRuns, e.File, e.Test)
+	}
+	for _, e := range info.UnmatchedQuarantine {
+		fmt.Fprintf(w, "# quarantine entry does not match any test: %s: %s\n", e.File, e.Test)
+	}
+	if warning := info.StoppedWarning(); warning != "" {
+		fmt.Fprintf(w, "# %s\n", warning)
+	}
+	if info.Focus {
+		fmt.Fprintf(w, "# %s\n", focusWarning)
+		if info.FailFocusRun {
+			fmt.Fprintf(w, "# %s\n", focusFailure)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func tap13JUnitOutput(file, name, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="0.5">
+<testsuite name="` + file + `" tests="1" time="0.5">
+    <testcase classname="` + file + `" name="` + name + `" time="0.5">` + body + `</testcase>
+</testsuite>
+</testsuites>
+`
+}
+
+func TestTAP13Results(t *testing.T) {
+	a := TestFile{Name: "a.bats"}
+	b := TestFile{Name: "b.bats"}
+	passed := func(file, name string) TestRun {
+		return TestRun{Runner: "lambda", Response: wire.RunTestResult{Output: tap13JUnitOutput(file, name, "")}}
+	}
+	failed := func(file, name string) TestRun {
+		return TestRun{Runner: "lambda", Response: wire.RunTestResult{Err: "exit status 1", Output: tap13JUnitOutput(file, name, "<failure>(in test file "+file+", line 3)\n  `false' failed</failure>")}}
+	}
+	files := []TestFile{
+		{Name: "a.bats", Tests: []Test{
+			{Name: "a: passes", File: a, Runs: []TestRun{passed("a.bats", "a: passes")}},
+			{Name: "a: skips", File: a, Runs: []TestRun{{Runner: "local", Response: wire.RunTestResult{Output: SkippedJUnitTestCaseOutput("a.bats", "a: skips", "no tty")}}}},
+			{Name: "a: skips quietly", File: a, Runs: []TestRun{{Runner: "skip", Response: wire.RunTestResult{Output: SkippedJUnitTestCaseOutput("a.bats", "a: skips quietly", "")}}}},
+			{Name: "a: not run", File: a},
+		}},
+		{Name: "b.bats", Tests: []Test{
+			{Name: "b: fails", File: b, Runs: []TestRun{failed("b.bats", "b: fails")}},
+			{Name: "b: flaky", File: b, Runs: []TestRun{passed("b.bats", "b: flaky"), failed("b.bats", "b: flaky")}},
+			{Name: "b: quarantined", File: b, Quarantine: &QuarantineEntry{Owner: "dustin", Reason: "port reuse"}, Runs: []TestRun{failed("b.bats", "b: quarantined")}},
+			{Name: "b: hangs", File: b, Runs: []TestRun{{Runner: "lambda", Response: wire.RunTestResult{TimedOut: true, Err: "timed out after 5s", Output: "partial output"}}}},
+		}},
+	}
+
+	var buf bytes.Buffer
+	WriteTAP13Results(&buf, files, RunInfo{Interrupted: true})
+	assert.Equal(t, `TAP version 13
+1..2
+# Subtest: a.bats
+    1..4
+    ok 1 - a: passes
+      ---
+      duration_ms: 500
+      runner: "lambda"
+      runs: 1
+      attempts: 1
+      ...
+    ok 2 - a: skips # SKIP no tty
+      ---
+      duration_ms: 0
+      runner: "local"
+      runs: 1
+      attempts: 1
+      ...
+    ok 3 - a: skips quietly # SKIP
+      ---
+      duration_ms: 0
+      runner: "skip"
+      runs: 1
+      attempts: 1
+      ...
+    ok 4 - a: not run # SKIP not run
+      ---
+      duration_ms: 0
+      runner: ""
+      runs: 0
+      attempts: 0
+      ...
+ok 1 - a.bats
+# Subtest: b.bats
+    1..4
+    not ok 1 - b: fails
+      ---
+      duration_ms: 500
+      runner: "lambda"
+      runs: 1
+      attempts: 1
+      severity: fail
+      output: |-
+        (in test file b.bats, line 3)
+          `+"`"+`false' failed
+      ...
+    not ok 2 - b: flaky
+      ---
+      duration_ms: 500
+      runner: "lambda"
+      runs: 2
+      attempts: 2
+      message: "flaky (passed 1/2)"
+      severity: fail
+      output: |-
+        1/2 runs failed with:
+        (in test file b.bats, line 3)
+          `+"`"+`false' failed
+      ...
+    not ok 3 - b: quarantined # TODO quarantined by dustin: port reuse
+      ---
+      duration_ms: 500
+      runner: "lambda"
+      runs: 1
+      attempts: 1
+      severity: fail
+      output: |-
+        (in test file b.bats, line 3)
+          `+"`"+`false' failed
+      ...
+    not ok 4 - b: hangs
+      ---
+      duration_ms: 0
+      runner: "lambda"
+      runs: 1
+      attempts: 1
+      message: "timed out"
+      severity: fail
+      output: |-
+        timed out after 5s
+        partial output
+      ...
+not ok 2 - b.bats
+# The run was interrupted; the tests which had not started yet were not run.
+`, buf.String())
+
+	// With --flaky pass, the flaky test does not fail its file.
+	files[1].Tests = files[1].Tests[1:3]
+	buf.Reset()
+	WriteTAP13Results(&buf, files, RunInfo{FlakesPass: true})
+	assert.Contains(t, buf.String(), "    ok 1 - b: flaky\n")
+	assert.Contains(t, buf.String(), "\nok 2 - b.bats\n")
+	assert.NotContains(t, buf.String(), "# The run was")
+}
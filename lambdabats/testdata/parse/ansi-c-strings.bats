@@ -0,0 +1,15 @@
+@test "ansi-c: escaped quote" {
+    echo $'it\'s'
+    cat <<EOF
+@test "inside a heredoc" {
+EOF
+}
+
+@test "ansi-c: spanning lines" {
+    printf $'a\'
+@test "inside a string" {
+b'
+}
+
+@test "ansi-c: after" {
+}
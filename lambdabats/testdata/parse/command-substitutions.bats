@@ -0,0 +1,24 @@
+@test "substitutions: nested quotes" {
+    x="$(echo "it's")"
+    cat <<EOF
+@test "inside a heredoc" {
+EOF
+}
+
+@test "substitutions: backticks" {
+    x="`echo "it's"`"
+    y=$(echo 'a' "b" `echo "it's"` $((1 << 2)))
+}
+
+@test "substitutions: spanning lines" {
+    x="$(
+        cat <<'EOF'
+@test "inside a heredoc in a substitution" {
+EOF
+        echo "it's" | (cat)
+# bats test_tags=inside
+    )"
+}
+
+@test "substitutions: after" {
+}
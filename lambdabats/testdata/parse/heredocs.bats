@@ -0,0 +1,40 @@
+@test "heredocs: plain" {
+    dolt sql <<SQL
+@test "inside a heredoc" {
+# bats test_tags=inside
+SQL
+}
+
+@test "heredocs: dash strips tabs" {
+    cat <<-EOF
+		@test "inside a heredoc" {
+	EOF
+}
+
+@test "heredocs: quoted delimiters" {
+    cat <<'END' > a.txt
+@test "inside" {
+END
+    cat << "END2"
+@test "inside" {
+END2
+    cat <<\END3
+@test "inside" {
+END3
+}
+
+@test "heredocs: not heredocs" {
+    cat <<< "@test"
+    echo $((1 << 2)) "<<NOPE"
+    echo '<<NOPE' # <<NOPE
+}
+
+@test "heredocs: two on one line" { cat <<A; cat <<B
+@test "inside" {
+A
+@test "inside" {
+B
+}
+
+@test "heredocs: after" {
+}
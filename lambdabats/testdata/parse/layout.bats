@@ -0,0 +1,38 @@
+load $BATS_TEST_DIRNAME/helper/common.bash
+
+setup() {
+    setup_common
+}
+
+    @test "layout: indented with spaces" {
+        true
+    }
+
+	@test "layout: indented with a tab" {
+	}
+
+@test "layout: brace on the next line"
+{
+    true
+}
+
+@test "layout: brace after a blank line"
+
+  {
+}
+
+@test	"layout: tab after @test"	{
+}
+
+@test "layout: body on the same line" { run true; [ "$status" -eq 0 ]; }
+
+@testing "not a test" {
+}
+
+# @test "commented out" {
+# }
+
+echo @test "not a test either" {
+
+@test "layout: windows line endings" {
+}
@@ -0,0 +1,6 @@
+@test "errors: fine" {
+}
+
+@test "errors: no brace"
+true
+}
@@ -0,0 +1,24 @@
+@test "strings: shift in a multi-line query" {
+    run dolt sql -q "
+select 1 << 2;
+"
+    [ "$status" -eq 0 ]
+}
+
+@test "strings: single-quoted" {
+    run dolt sql -q 'select 1 <<
+@test "inside a string" {
+# bats test_tags=inside
+'
+}
+
+# bats test_tags=after
+@test "strings: heredoc after a multi-line string" {
+    cat <<EOF "first
+second"
+@test "inside a heredoc" {
+EOF
+}
+
+@test "strings: after" {
+}
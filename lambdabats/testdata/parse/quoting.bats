@@ -0,0 +1,37 @@
+#!/usr/bin/env bats
+
+@test "quoting: double quoted" {
+    true
+}
+
+@test 'quoting: single quoted' {
+    true
+}
+
+@test quoting: unquoted words {
+    true
+}
+
+@test "quoting: escaped \"quotes\" and \\backslashes" {
+    true
+}
+
+@test "quoting: ends with a quote \"" {
+    true
+}
+
+@test "quoting: ends with a brace {" {
+    true
+}
+
+@test 'quoting: single quoted "double quotes" and \backslash' {
+    true
+}
+
+@test "quoting: literal \$dollar and \n" {
+    true
+}
+
+@test "quoting: "concatenated' parts' {
+    true
+}
@@ -0,0 +1,26 @@
+# bats file_tags=sql, slow
+
+# bats test_tags=no_lambda
+@test "tags: one tag" {
+}
+
+@test "tags: tags do not carry over" {
+}
+
+# bats test_tags=no_lambda, bats:focus,,  extra
+@test "tags: comma separated" {
+}
+
+#bats   test_tags=tight
+  # bats test_tags=indented  
+@test "tags: later comment replaces" {
+}
+
+# bats file_tags=
+@test "tags: file tags cleared" {
+}
+
+# bats file_tags=remote
+# bats test_tags is not a tag comment
+@test "tags: file tags replaced" {
+}
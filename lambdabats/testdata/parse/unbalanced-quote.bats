@@ -0,0 +1,7 @@
+@test "unbalanced: string" {
+    run dolt sql -q "select 1;
+}
+
+@test "unbalanced: after the string" {
+    cat <<EOF
+}
@@ -0,0 +1,7 @@
+@test "errors: heredoc" {
+    dolt sql <<SQL
+select 1;
+}
+
+@test "errors: swallowed by the heredoc" {
+}
@@ -0,0 +1,3 @@
+
+@test "errors: unterminated {
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// How long a test, or all the tests in a file, took.
+type Timing struct {
+	File string
+	// Empty for the totals of a file.
+	Test string
+	// The time bats reported for the test.
+	Bats time.Duration
+	// The wall-clock time it took to get the results back from the runner.
+	Latency time.Duration
+}
+
+// The ratio of the wall-clock latency to the time bats reported, or 0 if
+// bats reported no time.
+func (t Timing) Overhead() float64 {
+	if t.Bats == 0 {
+		return 0
+	}
+	return float64(t.Latency) / float64(t.Bats)
+}
+
+// The mean of the latencies of the runs of the test.
+func (t Test) MeanLatency() time.Duration {
+	if len(t.Runs) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, run := range t.Runs {
+		total += run.Latency
+	}
+	return total / time.Duration(len(t.Runs))
+}
+
+type TimingsReport struct {
+	// Every test, slowest first.
+	Tests []Timing
+	// The total of the tests in each file, slowest first.
+	Files []Timing
+	Total Timing
+}
+
+// Collect the timings of the tests. When a test was run more than once, the
+// mean of the runs is used.
+func NewTimingsReport(files []TestFile) TimingsReport {
+	var res TimingsReport
+	for _, f := range files {
+		total := Timing{File: f.Name}
+		for _, t := range f.Tests {
+			timing := Timing{
+				File:    f.Name,
+				Test:    t.Name,
+				Bats:    t.MeanDuration(),
+				Latency: t.MeanLatency(),
+			}
+			total.Bats += timing.Bats
+			total.Latency += timing.Latency
+			res.Tests = append(res.Tests, timing)
+		}
+		res.Total.Bats += total.Bats
+		res.Total.Latency += total.Latency
+		res.Files = append(res.Files, total)
+	}
+	slowest := func(timings []Timing) func(a, b int) bool {
+		return func(a, b int) bool {
+			return timings[a].Latency > timings[b].Latency
+		}
+	}
+	sort.SliceStable(res.Tests, slowest(res.Tests))
+	sort.SliceStable(res.Files, slowest(res.Files))
+	return res
+}
+
+func formatTiming(t Timing) string {
+	if t.Bats == 0 {
+		return fmt.Sprintf("%8.2fs latency", t.Latency.Seconds())
+	}
+	return fmt.Sprintf("%8.2fs latency, %8.2fs in bats, %5.2fx overhead", t.Latency.Seconds(), t.Bats.Seconds(), t.Overhead())
+}
+
+// Write the |n| slowest tests, the total time for each file, and how much
+// overhead running the tests remotely added on top of the time the tests
+// themselves took.
+func WriteTimingsReport(w io.Writer, files []TestFile, n int) error {
+	report := NewTimingsReport(files)
+	if _, err := fmt.Fprintf(w, "slowest %d tests\n", min(n, len(report.Tests))); err != nil {
+		return err
+	}
+	for _, t := range report.Tests[:min(n, len(report.Tests))] {
+		if _, err := fmt.Fprintf(w, "  %s  %s: %s\n", formatTiming(t), t.File, t.Test); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(w, "\nfile totals"); err != nil {
+		return err
+	}
+	for _, f := range report.Files {
+		if _, err := fmt.Fprintf(w, "  %s  %s\n", formatTiming(f), f.File); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\ntotal: %s\n", formatTiming(report.Total))
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dolthub/lambdabats/wire"
+)
+
+func TestTimingsReport(t *testing.T) {
+	passed := func(file, name, secs string, latency time.Duration) TestRun {
+		return TestRun{Latency: latency, Response: wire.RunTestResult{Output: `
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites time="` + secs + `">
+<testsuite name="` + file + `" tests="1" failures="0" errors="0" skipped="0" time="` + secs + `">
+    <testcase classname="` + file + `" name="` + name + `" time="` + secs + `" />
+</testsuite>
+</testsuites>
+`}}
+	}
+	files := []TestFile{
+		{Name: "a.bats", Tests: []Test{
+			{Name: "fast", Runs: []TestRun{passed("a.bats", "fast", "1", 2*time.Second)}},
+			{Name: "slow", Runs: []TestRun{
+				passed("a.bats", "slow", "10", 11*time.Second),
+				passed("a.bats", "slow", "20", 21*time.Second),
+			}},
+		}},
+		{Name: "b.bats", Tests: []Test{
+			{Name: "medium", Runs: []TestRun{passed("b.bats", "medium", "5", 6*time.Second)}},
+		}},
+	}
+
+	report := NewTimingsReport(files)
+	if assert.Len(t, report.Tests, 3) {
+		assert.Equal(t, Timing{File: "a.bats", Test: "slow", Bats: 15 * time.Second, Latency: 16 * time.Second}, report.Tests[0])
+		assert.Equal(t, "medium", report.Tests[1].Test)
+		assert.Equal(t, "fast", report.Tests[2].Test)
+	}
+	if assert.Len(t, report.Files, 2) {
+		assert.Equal(t, Timing{File: "a.bats", Bats: 16 * time.Second, Latency: 18 * time.Second}, report.Files[0])
+	}
+	assert.Equal(t, 21*time.Second, report.Total.Bats)
+	assert.Equal(t, 24*time.Second, report.Total.Latency)
+	assert.Equal(t, 2.0, report.Tests[2].Overhead())
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteTimingsReport(&buf, files, 1))
+	assert.Contains(t, buf.String(), "slowest 1 tests\n     16.00s latency,    15.00s in bats,  1.07x overhead  a.bats: slow\n")
+	assert.NotContains(t, buf.String(), "a.bats: fast")
+}
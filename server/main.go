@@ -17,11 +17,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -69,9 +72,11 @@ func handleRequest(ctx context.Context, request events.LambdaFunctionURLRequest)
 		return events.LambdaFunctionURLResponse{}, err
 	}
 
+	// Failing to fetch the test artifacts is not the test's fault. We
+	// return a 503 so that the client knows it can retry the test.
 	runLocation, newPath, err := UnpackTest(ctx, downloader, testReq.DoltLocation, testReq.BinLocation, testReq.BatsLocation)
 	if err != nil {
-		return events.LambdaFunctionURLResponse{}, err
+		return events.LambdaFunctionURLResponse{Body: fmt.Sprintf("could not download test artifacts: %v", err), StatusCode: 503}, nil
 	}
 
 	var res wire.RunTestResult
@@ -112,7 +117,13 @@ func handleRequest(ctx context.Context, request events.LambdaFunctionURLRequest)
 		return events.LambdaFunctionURLResponse{}, err
 	}
 
-	cmd := exec.Command("bats")
+	cmdCtx := ctx
+	if testReq.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, time.Duration(testReq.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(cmdCtx, "bats")
 	if cmd.Err != nil {
 		return events.LambdaFunctionURLResponse{}, cmd.Err
 	}
@@ -125,11 +136,24 @@ func handleRequest(ctx context.Context, request events.LambdaFunctionURLRequest)
 	cmd.Args = []string{
 		"bats", "-F", "junit", "-f", testReq.TestFilter, testReq.FileName,
 	}
+	// A hung test is usually stuck waiting on something it started, like
+	// dolt sql-server, which would keep the output pipe open after bats
+	// itself was killed. Run bats in its own process group and kill all of
+	// it.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 10 * time.Second
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		res.Err = err.Error()
 	}
 	res.Output = string(output)
+	if testReq.TimeoutSeconds > 0 && errors.Is(cmdCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		res.TimedOut = true
+		res.Err = fmt.Sprintf("timed out after %ds", testReq.TimeoutSeconds)
+	}
 
 	body, err := json.Marshal(res)
 	if err != nil {
@@ -39,9 +39,17 @@ type RunTestRequest struct {
 
 	// Environment variables to set while running the tests.
 	EnvVars []string `json:"env_vars"`
+
+	// If non-zero, the bats invocation and everything it started are
+	// killed after this many seconds, and the result has TimedOut set.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
 }
 
 type RunTestResult struct {
 	Output string `json:"output"`
 	Err    string `json:"err"`
+
+	// The test ran longer than the request's timeout_seconds and was
+	// killed. Output has whatever bats wrote before then.
+	TimedOut bool `json:"timed_out,omitempty"`
 }